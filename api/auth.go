@@ -0,0 +1,422 @@
+package api
+
+import (
+	"UPC-GO/config"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 认证: 请求通过 Authorization: Bearer <token>、X-API-Key 头，或者 ?token= 查询参数（WebSocket 无法设置请求头）携带凭证
+// token 可以是静态的 API key，也可以是 HMAC 签名的 JWT，在配置文件的 auth 中配置:
+//   apiKeys         静态 API key 和对应的角色，环境变量 API_KEYS="key1:admin,key2:viewer"
+//   jwtSecret       JWT 的签名密钥，JWT 的 role 声明是角色，sub 声明是用户名
+//   allowedOrigins  允许连接 /ws 的来源，默认只允许同源，"*" 允许所有来源
+// 如果没有配置任何认证方式，所有请求都以 admin 角色处理，和之前的行为一致
+
+// Role 是用户的角色，权限从低到高依次是 viewer、operator、admin
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+// 角色的等级，等级高的角色拥有等级低的角色的所有权限
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Permission 是一类操作
+type Permission string
+
+const (
+	PermPublic       Permission = "public"        // 不需要认证，例如首页和静态文件
+	PermRead         Permission = "read"          // 查看和下载文件、镜像、容器、构建
+	PermUpload       Permission = "upload"        // 上传文件、创建文件夹
+	PermDeleteFiles  Permission = "delete-files"  // 删除文件和结果
+	PermBuild        Permission = "build"         // 构建镜像、取消构建
+	PermPullImages   Permission = "pull-images"   // 拉取镜像
+	PermRemoveImages Permission = "remove-images" // 删除镜像
+	PermContainers   Permission = "containers"    // 创建、启动、停止、删除容器
+	PermJobs         Permission = "jobs"          // 运行处理任务
+	PermExec         Permission = "exec"          // 在容器中打开终端
+	PermTerminal     Permission = "terminal"      // 使用本机终端
+	PermAdmin        Permission = "admin"         // 其它所有操作
+)
+
+// 每类操作需要的最低角色
+var PermissionRoles = map[Permission]Role{
+	PermRead:         RoleViewer,
+	PermUpload:       RoleOperator,
+	PermDeleteFiles:  RoleOperator,
+	PermBuild:        RoleOperator,
+	PermPullImages:   RoleOperator,
+	PermContainers:   RoleOperator,
+	PermJobs:         RoleOperator,
+	PermExec:         RoleOperator,
+	PermRemoveImages: RoleAdmin,
+	PermTerminal:     RoleAdmin,
+	PermAdmin:        RoleAdmin,
+}
+
+// Principal 是通过认证的用户
+type Principal struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// Allows 判断用户是否有某类操作的权限
+func (p *Principal) Allows(perm Permission) bool {
+	if perm == PermPublic {
+		return true
+	}
+	required, ok := PermissionRoles[perm]
+	if !ok {
+		required = RoleAdmin
+	}
+	return roleLevels[p.Role] >= roleLevels[required]
+}
+
+// Authenticator 根据 token 认证用户，token 不属于这种认证方式时返回 errUnknownToken
+type Authenticator interface {
+	Authenticate(token string) (*Principal, error)
+}
+
+var (
+	errUnknownToken = errors.New("unknown token")
+	errNoToken      = errors.New("missing credentials")
+)
+
+// 已经配置的认证方式，为空时不做认证
+var Authenticators []Authenticator
+
+// 允许连接 /ws 的来源
+var AllowedOrigins []string
+
+// ************************************************  API key  ************************************************
+
+// APIKeyAuthenticator 使用静态的 API key 认证
+type APIKeyAuthenticator struct {
+	Keys map[string]Role
+}
+
+// NewAPIKeyAuthenticator 使用配置中的 API key 创建认证方式
+func NewAPIKeyAuthenticator(keys []config.APIKey) (*APIKeyAuthenticator, error) {
+	auth := &APIKeyAuthenticator{Keys: make(map[string]Role)}
+	for _, key := range keys {
+		if key.Key == "" {
+			return nil, errors.New("empty API key")
+		}
+		if _, ok := roleLevels[Role(key.Role)]; !ok {
+			return nil, fmt.Errorf("unknown role %q", key.Role)
+		}
+		auth.Keys[key.Key] = Role(key.Role)
+	}
+	return auth, nil
+}
+
+func (a *APIKeyAuthenticator) Authenticate(token string) (*Principal, error) {
+	for key, role := range a.Keys {
+		// 使用固定时间比较，避免时序攻击
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			// 不在日志中暴露完整的 key
+			name := "api-key:" + key[:min(4, len(key))] + "…"
+			return &Principal{Name: name, Role: role}, nil
+		}
+	}
+	return nil, errUnknownToken
+}
+
+// ************************************************  JWT  ************************************************
+
+// JWTAuthenticator 使用 HMAC 签名（HS256、HS384、HS512）的 JWT 认证
+type JWTAuthenticator struct {
+	Secret []byte
+}
+
+// JWT 中我们使用的声明
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	ExpiresAt *int64 `json:"exp"`
+	NotBefore *int64 `json:"nbf"`
+}
+
+// JWT 签名算法对应的哈希函数
+var jwtAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+func (a *JWTAuthenticator) Authenticate(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errUnknownToken
+	}
+
+	// 解析头部，只接受 HMAC 算法
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errUnknownToken
+	}
+	newHash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+	}
+
+	// 验证签名
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed JWT signature")
+	}
+	mac := hmac.New(newHash, a.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("invalid JWT signature")
+	}
+
+	// 验证有效期和角色
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed JWT claims")
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt != nil && now >= *claims.ExpiresAt {
+		return nil, errors.New("JWT expired")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return nil, errors.New("JWT not valid yet")
+	}
+	if _, ok := roleLevels[claims.Role]; !ok {
+		return nil, fmt.Errorf("unknown role %q in JWT", claims.Role)
+	}
+	return &Principal{Name: claims.Subject, Role: claims.Role}, nil
+}
+
+// 解码JWT的一部分
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// SignJWT 用 HS256 签发一个 JWT，用于测试和命令行工具
+func SignJWT(secret []byte, subject string, role Role, ttl time.Duration) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := map[string]interface{}{"sub": subject, "role": role, "iat": time.Now().Unix()}
+	if ttl > 0 {
+		claims["exp"] = time.Now().Add(ttl).Unix()
+	}
+	payload, _ := json.Marshal(claims)
+	body := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(body))
+	return body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ************************************************  中间件  ************************************************
+
+type principalKey struct{}
+
+// PrincipalFrom 返回请求的用户，没有认证时为 nil
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// RequestToken 从请求中取出 token，没有时返回空字符串
+func RequestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("token")
+}
+
+// 依次尝试所有认证方式
+func authenticate(r *http.Request) (*Principal, error) {
+	token := RequestToken(r)
+	if token == "" {
+		return nil, errNoToken
+	}
+	for _, authenticator := range Authenticators {
+		principal, err := authenticator.Authenticate(token)
+		if err == errUnknownToken {
+			continue
+		}
+		return principal, err
+	}
+	return nil, errors.New("invalid credentials")
+}
+
+// 根据请求的路径和方法判断需要的权限
+func requiredPermission(r *http.Request) Permission {
+	p := legacyPath(r.URL.Path)
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	has := func(prefix string) bool { return p == prefix || strings.HasPrefix(p, prefix+"/") }
+
+	switch {
+	case p == "/" || p == "/api" || strings.HasPrefix(p, "/static/"):
+		return PermPublic
+	case p == "/api/openapi.json" || p == "/api/docs":
+		return PermPublic // 接口文档不包含数据
+	case strings.HasPrefix(p, "/backend/"):
+		return PermPublic // 节点注册使用 REGISTRY_TOKEN
+	case strings.HasPrefix(p, "/api/nodes/"):
+		// 转发到节点的请求需要和节点上相同的权限，至少需要 read，节点上公开的路径通过注册中心也不公开
+		_, rest, proxied := strings.Cut(strings.TrimPrefix(p, "/api/nodes/"), "/")
+		if !proxied {
+			return PermRead
+		}
+		out := r.Clone(r.Context())
+		out.URL.Path = "/" + rest
+		if perm := requiredPermission(out); perm != PermPublic {
+			return perm
+		}
+		return PermRead
+	case p == "/api/nodes":
+		return PermRead
+	case p == "/ws" || p == "/api/ws":
+		return terminalPermission(r)
+	case has("/api/terminals"):
+		// 可以在容器中打开终端的用户也可以管理自己的会话，会话只对创建者和 admin 可见
+		return PermExec
+	case p == "/api/files/download" || p == "/api/results/download":
+		return PermRead // POST 只是用来传递文件列表
+	case has("/api/files") || has("/api/results") || has("/api/upload") || has("/api/tus"):
+		switch {
+		case read:
+			return PermRead
+		case r.Method == http.MethodDelete && !has("/api/tus"):
+			return PermDeleteFiles
+		case r.Method == http.MethodPost && strings.HasPrefix(p, "/api/files/") && !r.URL.Query().Has("mkdir"):
+			return PermBuild
+		default:
+			return PermUpload
+		}
+	case has("/api/builds"):
+		if read {
+			return PermRead
+		}
+		return PermBuild
+	case has("/api/images"):
+		if read {
+			return PermRead
+		}
+		return PermRemoveImages
+	case has("/api/pull"):
+		return PermPullImages
+	case has("/api/containers"):
+		if read {
+			return PermRead
+		}
+		return PermContainers
+	case p == "/api/registration" || p == "/metrics":
+		return PermRead
+	case has("/api/jobs"):
+		return PermJobs
+	default:
+		return PermAdmin
+	}
+}
+
+// 在容器中执行的终端不需要本机终端的权限
+func terminalPermission(r *http.Request) Permission {
+	query := r.URL.Query()
+	if id := query.Get("session"); id != "" {
+		if s := getSession(id); s != nil && s.Info().Container != "" {
+			return PermExec
+		}
+		return PermTerminal
+	}
+	if query.Get("container") != "" {
+		return PermExec
+	}
+	return PermTerminal
+}
+
+// AuthMiddleware 认证每个请求，并检查用户是否有对应的权限
+func AuthMiddleware(next http.Handler) http.Handler {
+	if len(Authenticators) == 0 {
+		slog.Warn("No API_KEYS or JWT_SECRET configured, authentication is disabled")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 预检请求不带凭证
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 没有配置认证时，所有请求都是 admin
+		if len(Authenticators) == 0 {
+			ctx := context.WithValue(r.Context(), principalKey{}, &Principal{Name: "anonymous", Role: RoleAdmin})
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		perm := requiredPermission(r)
+		if perm == PermPublic {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := authenticate(r)
+		if err != nil {
+			Cors(w)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="upc"`)
+			WriteError(w, http.StatusUnauthorized, "Authentication required", err)
+			return
+		}
+		if !principal.Allows(perm) {
+			Cors(w)
+			WriteError(w, http.StatusForbidden, fmt.Sprintf("Role %s is not allowed to %s", principal.Role, perm), nil)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey{}, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// 检查 WebSocket 连接的来源
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// 不是浏览器发起的连接
+		return true
+	}
+	for _, allowed := range AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	// 默认只允许同源
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,399 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	fpath "path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+)
+
+// post /api/files/:filename 是把一个zip文件加入构建队列，返回构建任务
+// get /api/builds 是获取所有构建任务的列表
+// get /api/builds/:id 是获取一个构建任务的状态
+// get /api/builds/:id/logs 是实时获取构建日志, ?follow=false 只返回当前已有的日志
+// post /api/builds/:id/cancel 是取消一个构建任务，结束正在运行的 pack 进程
+
+// 构建任务的状态
+const (
+	BuildQueued    = "queued"
+	BuildRunning   = "running"
+	BuildSucceeded = "succeeded"
+	BuildFailed    = "failed"
+	BuildCanceled  = "canceled"
+)
+
+// 同时运行的构建数量，pack build 很占资源，超出的任务会排队
+var maxConcurrentBuilds = 2
+
+// 内存中最多保留的已结束构建任务数量
+var maxFinishedBuilds = 100
+
+// 使用的 buildpack builder 和 pack 命令
+var builderImage = "paketobuildpacks/builder-jammy-base"
+var packPath = "pack"
+
+// BuildInfo 是返回给客户端的构建任务信息
+type BuildInfo struct {
+	Id       string `json:"Id"`
+	File     string `json:"File"`
+	Image    string `json:"Image"`
+	Status   string `json:"Status"`
+	Error    string `json:"Error,omitempty"`
+	Created  string `json:"Created"`
+	Started  string `json:"Started,omitempty"`
+	Finished string `json:"Finished,omitempty"`
+}
+
+// Build 是一个构建任务，日志会一直保存在内存中，方便客户端随时读取
+type Build struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	info     BuildInfo
+	created  time.Time
+	started  time.Time
+	logs     []byte
+	done     bool
+	canceled bool
+	cancel   context.CancelFunc
+}
+
+// 所有构建任务
+var (
+	buildsMu   sync.Mutex
+	builds     = make(map[string]*Build)
+	buildSlots = make(chan struct{}, maxConcurrentBuilds)
+)
+
+// 生成一个随机ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Write 把日志追加到构建任务中，并唤醒等待日志的客户端
+func (b *Build) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	b.logs = append(b.logs, p...)
+	b.mu.Unlock()
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// 写一行日志
+func (b *Build) logf(format string, args ...interface{}) {
+	fmt.Fprintf(b, format+"\n", args...)
+}
+
+// Info 返回构建任务当前的状态
+func (b *Build) Info() BuildInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.info
+}
+
+// 修改构建任务的状态
+func (b *Build) setStatus(status string, err error) {
+	b.mu.Lock()
+	now := time.Now().Format(time.RFC3339)
+	b.info.Status = status
+	if status == BuildRunning {
+		b.info.Started = now
+		b.started = time.Now()
+	}
+	if status == BuildSucceeded || status == BuildFailed || status == BuildCanceled {
+		b.info.Finished = now
+		b.done = true
+		observeBuild(status, b.started)
+	}
+	if err != nil {
+		b.info.Error = err.Error()
+	}
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// Cancel 取消构建任务，排队中的任务直接结束，运行中的任务会结束 pack 进程
+func (b *Build) Cancel() bool {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return false
+	}
+	b.canceled = true
+	b.mu.Unlock()
+	b.cancel()
+	return true
+}
+
+// 根据zip文件名生成镜像名称，例如 apps/Demo.zip -> demo
+// 文件名不是合法的镜像名称时返回错误，例如包含空格、+ 或者以 - 开头
+func buildImageName(filename string) (string, error) {
+	image := strings.ToLower(strings.TrimSuffix(path.Base(filename), ".zip"))
+	if _, err := reference.ParseNormalizedNamed(image); err != nil {
+		return "", fmt.Errorf("invalid image name %q: %w", image, err)
+	}
+	return image, nil
+}
+
+// StartBuild 创建一个构建任务并加入队列，image 是 buildImageName 生成的镜像名称
+// ctx 是创建构建的请求，构建的日志中带上它的请求ID，请求结束后构建继续运行
+func StartBuild(ctx context.Context, filename, image string) *Build {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	b := &Build{
+		info: BuildInfo{
+			Id:      newID(),
+			File:    filename,
+			Image:   image,
+			Status:  BuildQueued,
+			Created: time.Now().Format(time.RFC3339),
+		},
+		created: time.Now(),
+		cancel:  cancel,
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	buildsMu.Lock()
+	builds[b.info.Id] = b
+	pruneBuilds()
+	buildsMu.Unlock()
+
+	go runBuild(ctx, b)
+	return b
+}
+
+// 删除最早结束的构建任务，调用时需要持有 buildsMu
+func pruneBuilds() {
+	var finished []*Build
+	for _, b := range builds {
+		b.mu.Lock()
+		if b.done {
+			finished = append(finished, b)
+		}
+		b.mu.Unlock()
+	}
+	if len(finished) <= maxFinishedBuilds {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].created.Before(finished[j].created) })
+	for _, b := range finished[:len(finished)-maxFinishedBuilds] {
+		delete(builds, b.info.Id)
+	}
+}
+
+// 找到一个构建任务
+func getBuild(id string) *Build {
+	buildsMu.Lock()
+	defer buildsMu.Unlock()
+	return builds[id]
+}
+
+// 运行构建任务：解压zip文件，然后用 pack build 创建docker image
+func runBuild(ctx context.Context, b *Build) {
+	defer b.cancel()
+	info := b.Info()
+
+	// 等待空闲的构建位置，释放到取得位置的同一个 channel
+	slots := buildSlots
+	b.logf("Queued build of %s", info.File)
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		b.logf("Build canceled")
+		b.setStatus(BuildCanceled, nil)
+		slog.InfoContext(ctx, "Build canceled while queued", "build", info.Id, "file", info.File)
+		return
+	}
+	b.setStatus(BuildRunning, nil)
+	slog.InfoContext(ctx, "Build started", "build", info.Id, "file", info.File, "image", info.Image)
+
+	// 解压到这个构建自己的临时文件夹，不会覆盖 ./uploads 中的文件，同一个zip的多个构建互不影响
+	filePosition, err := resolvePath(filepath, info.File)
+	if err != nil {
+		finishBuild(ctx, b, err)
+		return
+	}
+	if err := os.MkdirAll(jobpath, os.ModePerm); err != nil {
+		finishBuild(ctx, b, fmt.Errorf("error creating the build folder: %w", err))
+		return
+	}
+	unzipPosition, err := os.MkdirTemp(jobpath, "build-")
+	if err != nil {
+		finishBuild(ctx, b, fmt.Errorf("error creating the build folder: %w", err))
+		return
+	}
+	// 构建结束后删除临时文件夹
+	defer func() {
+		os.RemoveAll(unzipPosition)
+		slog.DebugContext(ctx, "Removed build directory", "build", info.Id, "path", unzipPosition)
+	}()
+
+	// 解压文件
+	b.logf("$ unzip -o %s -d %s", filePosition, unzipPosition)
+	unzip := exec.CommandContext(ctx, "unzip", "-o", filePosition, "-d", unzipPosition)
+	unzip.Stdout = b
+	unzip.Stderr = b
+	if err := unzip.Run(); err != nil {
+		finishBuild(ctx, b, fmt.Errorf("error unzipping file: %w", err))
+		return
+	}
+	slog.DebugContext(ctx, "Unzip success", "build", info.Id, "file", info.File)
+
+	// zip 中的文件夹和 zip 文件同名，没有这个文件夹时使用zip的根目录
+	destPosition := fpath.Join(unzipPosition, strings.TrimSuffix(fpath.Base(filePosition), ".zip"))
+	if stat, err := os.Stat(destPosition); err != nil || !stat.IsDir() {
+		destPosition = unzipPosition
+	}
+
+	// 通过 exec 执行 buildpack 创建docker image
+	b.logf("$ pack build %s --path %s --builder %s", info.Image, destPosition, builderImage)
+	pack := exec.CommandContext(ctx, packPath, "build", info.Image, "--path", destPosition, "--builder", builderImage)
+	pack.Stdout = b
+	pack.Stderr = b
+	if err := pack.Run(); err != nil {
+		finishBuild(ctx, b, fmt.Errorf("error building image: %w", err))
+		return
+	}
+
+	finishBuild(ctx, b, nil)
+}
+
+// 根据结果结束构建任务
+func finishBuild(ctx context.Context, b *Build, err error) {
+	b.mu.Lock()
+	canceled := b.canceled
+	b.mu.Unlock()
+
+	info := b.Info()
+	switch {
+	case canceled:
+		b.logf("Build canceled")
+		b.setStatus(BuildCanceled, nil)
+		slog.InfoContext(ctx, "Build canceled", "build", info.Id, "file", info.File)
+	case err != nil:
+		b.logf("Build failed: %v", err)
+		b.setStatus(BuildFailed, err)
+		slog.ErrorContext(ctx, "Build failed", "build", info.Id, "file", info.File, "error", err)
+	default:
+		b.logf("Build success: %s", info.Image)
+		b.setStatus(BuildSucceeded, nil)
+		slog.InfoContext(ctx, "Build success", "build", info.Id, "file", info.File, "image", info.Image)
+	}
+}
+
+// 获取所有构建任务的列表，按创建时间倒序
+func BuildsHandler(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+
+	buildsMu.Lock()
+	all := make([]*Build, 0, len(builds))
+	for _, b := range builds {
+		all = append(all, b)
+	}
+	buildsMu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].created.After(all[j].created) })
+
+	infos := make([]BuildInfo, 0, len(all))
+	for _, b := range all {
+		infos = append(infos, b.Info())
+	}
+	json.NewEncoder(w).Encode(infos)
+}
+
+// 找到路径中 {id} 对应的构建任务，没有时返回 404
+func buildOrError(w http.ResponseWriter, r *http.Request) *Build {
+	id := r.PathValue("id")
+	b := getBuild(id)
+	if b == nil {
+		WriteError(w, http.StatusNotFound, "Build not found: "+id, nil)
+	}
+	return b
+}
+
+// 获取一个构建任务的状态
+func ViewBuild(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+	if b := buildOrError(w, r); b != nil {
+		json.NewEncoder(w).Encode(b.Info())
+	}
+}
+
+// 取消一个构建任务
+func CancelBuild(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+	b := buildOrError(w, r)
+	if b == nil {
+		return
+	}
+	if !b.Cancel() {
+		WriteError(w, http.StatusConflict, "Build already finished: "+b.Info().Status, nil)
+		return
+	}
+	slog.InfoContext(r.Context(), "Canceling build", "build", b.Info().Id, "file", b.Info().File)
+	json.NewEncoder(w).Encode(b.Info())
+}
+
+// 实时输出构建日志，直到构建结束或客户端断开
+func BuildLogs(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+	b := buildOrError(w, r)
+	if b == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	follow := r.URL.Query().Get("follow") != "false"
+	flusher, _ := w.(http.Flusher)
+
+	// 客户端断开时唤醒等待中的循环
+	ctx := r.Context()
+	// 持有 b.mu 再唤醒，避免在循环检查 ctx.Err() 之后、调用 Wait 之前唤醒而丢失
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+	defer stop()
+
+	offset := 0
+	for {
+		b.mu.Lock()
+		for follow && offset == len(b.logs) && !b.done && ctx.Err() == nil {
+			b.cond.Wait()
+		}
+		chunk := b.logs[offset:]
+		done := b.done
+		b.mu.Unlock()
+
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			offset += len(chunk)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if !follow || ctx.Err() != nil || (done && len(chunk) == 0) {
+			return
+		}
+	}
+}
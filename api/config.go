@@ -0,0 +1,39 @@
+package api
+
+import (
+	"UPC-GO/config"
+)
+
+// Configure 使用配置文件中的存储目录、限制、builder 和认证配置，需要在启动服务器之前调用
+func Configure(cfg *config.Config) error {
+	filepath = cfg.Storage.Uploads
+	resultpath = cfg.Storage.Results
+	jobpath = cfg.Storage.Jobs
+	tuspath = cfg.Storage.Tus
+
+	formMemory = int64(cfg.Limits.FormMemory)
+	maxUploadSize = int64(cfg.Limits.MaxUploadSize)
+	maxConcurrentBuilds = cfg.Limits.MaxConcurrentBuilds
+	buildSlots = make(chan struct{}, maxConcurrentBuilds)
+	maxFinishedBuilds = cfg.Limits.MaxFinishedBuilds
+	SessionIdleTimeout = cfg.Limits.TerminalIdleTimeout.D()
+	MaxSessions = cfg.Limits.MaxTerminalSessions
+	tusExpiry = cfg.Limits.UploadExpiry.D()
+
+	builderImage = cfg.Builder.Image
+	packPath = cfg.Builder.Pack
+
+	Authenticators = nil
+	if len(cfg.Auth.APIKeys) > 0 {
+		apiKeys, err := NewAPIKeyAuthenticator(cfg.Auth.APIKeys)
+		if err != nil {
+			return err
+		}
+		Authenticators = append(Authenticators, apiKeys)
+	}
+	if cfg.Auth.JWTSecret != "" {
+		Authenticators = append(Authenticators, &JWTAuthenticator{Secret: []byte(cfg.Auth.JWTSecret)})
+	}
+	AllowedOrigins = cfg.Auth.AllowedOrigins
+	return nil
+}
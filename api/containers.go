@@ -1 +1,309 @@
 package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// get /api/containers 是获取所有docker containers 的列表, ?all=false 只返回运行中的容器
+// post /api/containers 是创建一个docker container, ?start=true 创建后立即启动
+// get /api/containers/:id 是获取一个docker container 的详细信息
+// delete /api/containers/:id 是删除一个docker container, ?force=true 强制删除运行中的容器
+// post /api/containers/:id/start 是启动一个docker container
+// post /api/containers/:id/stop 是停止一个docker container, ?timeout=10 等待秒数
+// post /api/containers/:id/restart 是重启一个docker container, ?timeout=10 等待秒数
+
+// ContainerPort 定义了容器端口映射的结构
+type ContainerPort struct {
+	IP          string `json:"IP,omitempty"`
+	PrivatePort uint16 `json:"PrivatePort"`
+	PublicPort  uint16 `json:"PublicPort,omitempty"`
+	Type        string `json:"Type"`
+}
+
+// ContainerSummary 定义了我们想要返回的容器信息的结构
+type ContainerSummary struct {
+	Id      string            `json:"Id"`
+	Name    string            `json:"Name"`
+	Image   string            `json:"Image"`
+	ImageId string            `json:"ImageId"`
+	Command string            `json:"Command"`
+	State   string            `json:"State"`
+	Status  string            `json:"Status"`
+	Created string            `json:"Created"`
+	Ports   []ContainerPort   `json:"Ports"`
+	Labels  map[string]string `json:"Labels"`
+}
+
+// ContainerCreateRequest 是创建容器时的请求体
+type ContainerCreateRequest struct {
+	Image  string            `json:"image"`
+	Name   string            `json:"name"`
+	Cmd    []string          `json:"cmd"`
+	Env    []string          `json:"env"`
+	Ports  []string          `json:"ports"` // 和 docker run -p 相同的格式, 例如 "8080:80/tcp"
+	Labels map[string]string `json:"labels"`
+}
+
+// 把docker返回的容器信息转换为 ContainerSummary
+func formatContainer(c types.Container) ContainerSummary {
+	name := ""
+	if len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+
+	ports := make([]ContainerPort, 0, len(c.Ports))
+	for _, p := range c.Ports {
+		ports = append(ports, ContainerPort{IP: p.IP, PrivatePort: p.PrivatePort, PublicPort: p.PublicPort, Type: p.Type})
+	}
+
+	return ContainerSummary{
+		Id:      c.ID,
+		Name:    name,
+		Image:   c.Image,
+		ImageId: c.ImageID,
+		Command: c.Command,
+		State:   c.State,
+		Status:  c.Status,
+		Created: time.Unix(c.Created, 0).Format(time.RFC3339),
+		Ports:   ports,
+		Labels:  c.Labels,
+	}
+}
+
+// 列出所有容器
+func ListContainers(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+	cli, err := newDockerClient()
+	if err != nil {
+		writeDockerError(w, "Error creating Docker client", err)
+		return
+	}
+	defer cli.Close()
+
+	// 默认返回所有容器，包括已经停止的
+	all := r.URL.Query().Get("all") != "false"
+	containers, err := cli.ContainerList(r.Context(), container.ListOptions{All: all})
+	if err != nil {
+		writeDockerError(w, "Error listing Docker containers", err)
+		return
+	}
+
+	summaries := make([]ContainerSummary, 0, len(containers))
+	for _, c := range containers {
+		summaries = append(summaries, formatContainer(c))
+	}
+
+	// 返回所有容器
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// 查看一个容器的详细信息
+func ViewContainer(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	id := r.PathValue("id")
+	cli, err := newDockerClient()
+	if err != nil {
+		writeDockerError(w, "Error creating Docker client", err)
+		return
+	}
+	defer cli.Close()
+
+	// 通过ID精确查找容器，保证返回的格式和列表一致
+	summary, err := findContainer(r, cli, id)
+	if client.IsErrNotFound(err) {
+		WriteError(w, http.StatusNotFound, "Container not found: "+id, nil)
+		return
+	} else if err != nil {
+		writeDockerError(w, "Error inspecting Docker container", err)
+		return
+	}
+
+	// 返回一个数组，方便前端处理
+	json.NewEncoder(w).Encode([]ContainerSummary{summary})
+}
+
+// 通过ID或名称找到一个容器
+func findContainer(r *http.Request, cli *client.Client, id string) (ContainerSummary, error) {
+	inspect, err := cli.ContainerInspect(r.Context(), id)
+	if err != nil {
+		return ContainerSummary{}, err
+	}
+
+	containers, err := cli.ContainerList(r.Context(), container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("id", inspect.ID)),
+	})
+	if err != nil {
+		return ContainerSummary{}, err
+	}
+	for _, c := range containers {
+		if c.ID == inspect.ID {
+			return formatContainer(c), nil
+		}
+	}
+	return ContainerSummary{}, fmt.Errorf("container %s disappeared while inspecting", id)
+}
+
+// 创建一个容器
+func CreateContainer(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	// 解析请求体
+	var requestData ContainerCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Error parsing request body", err)
+		return
+	}
+	if requestData.Image == "" {
+		WriteError(w, http.StatusBadRequest, "Error: no image name", nil)
+		return
+	}
+
+	// 解析端口映射
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(requestData.Ports)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Error parsing ports", err)
+		return
+	}
+
+	cli, err := newDockerClient()
+	if err != nil {
+		writeDockerError(w, "Error creating Docker client", err)
+		return
+	}
+	defer cli.Close()
+
+	config := &container.Config{
+		Image:        requestData.Image,
+		Cmd:          requestData.Cmd,
+		Env:          requestData.Env,
+		Labels:       requestData.Labels,
+		ExposedPorts: exposedPorts,
+	}
+	hostConfig := &container.HostConfig{PortBindings: portBindings}
+
+	created, err := cli.ContainerCreate(r.Context(), config, hostConfig, nil, nil, requestData.Name)
+	if client.IsErrNotFound(err) {
+		WriteError(w, http.StatusNotFound, "Image not found: "+requestData.Image, nil)
+		return
+	} else if err != nil {
+		writeDockerError(w, "Error creating Docker container", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Container created", "container", created.ID)
+
+	// 如果需要，创建后立即启动
+	if r.URL.Query().Get("start") == "true" {
+		if err := cli.ContainerStart(r.Context(), created.ID, container.StartOptions{}); err != nil {
+			writeDockerError(w, "Error starting Docker container", err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Container started", "container", created.ID)
+	}
+
+	summary, err := findContainer(r, cli, created.ID)
+	if err != nil {
+		writeDockerError(w, "Error inspecting Docker container", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// ContainerAction 返回启动、停止或重启容器的处理函数，action 是 start、stop 或 restart
+func ContainerAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Cors(w)
+		containerAction(w, r, r.PathValue("id"), action)
+	}
+}
+
+// 启动、停止或重启一个容器
+func containerAction(w http.ResponseWriter, r *http.Request, id, action string) {
+	cli, err := newDockerClient()
+	if err != nil {
+		writeDockerError(w, "Error creating Docker client", err)
+		return
+	}
+	defer cli.Close()
+
+	// 停止和重启时等待的秒数，不指定则使用docker的默认值
+	stopOptions := container.StopOptions{}
+	if timeout := r.URL.Query().Get("timeout"); timeout != "" {
+		seconds, err := strconv.Atoi(timeout)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "Error: invalid timeout", nil)
+			return
+		}
+		stopOptions.Timeout = &seconds
+	}
+
+	switch action {
+	case "start":
+		err = cli.ContainerStart(r.Context(), id, container.StartOptions{})
+	case "stop":
+		err = cli.ContainerStop(r.Context(), id, stopOptions)
+	case "restart":
+		err = cli.ContainerRestart(r.Context(), id, stopOptions)
+	default:
+		WriteError(w, http.StatusBadRequest, "Error: unknown action "+action, nil)
+		return
+	}
+	if client.IsErrNotFound(err) {
+		WriteError(w, http.StatusNotFound, "Container not found: "+id, nil)
+		return
+	} else if err != nil {
+		writeDockerError(w, "Error running "+action+" on Docker container", err)
+		return
+	}
+
+	summary, err := findContainer(r, cli, id)
+	if err != nil {
+		writeDockerError(w, "Error inspecting Docker container", err)
+		return
+	}
+
+	// 返回操作后的容器状态
+	slog.InfoContext(r.Context(), "Container "+action, "container", id)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// 删除一个容器
+func ContainerDeleter(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	id := r.PathValue("id")
+	cli, err := newDockerClient()
+	if err != nil {
+		writeDockerError(w, "Error creating Docker client", err)
+		return
+	}
+	defer cli.Close()
+
+	force := r.URL.Query().Get("force") == "true"
+	err = cli.ContainerRemove(r.Context(), id, container.RemoveOptions{Force: force, RemoveVolumes: true})
+	if client.IsErrNotFound(err) {
+		WriteError(w, http.StatusNotFound, "Container not found: "+id, nil)
+		return
+	} else if err != nil {
+		writeDockerError(w, "Error removing Docker container", err)
+		return
+	}
+
+	// 返回删除成功
+	slog.InfoContext(r.Context(), "Container deleted", "container", id)
+	json.NewEncoder(w).Encode("Delete success: " + id)
+}
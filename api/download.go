@@ -1,147 +1,288 @@
 package api
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	fpath "path/filepath"
 	"strings"
 )
 
 // ****************************************************  单文件  *****************************************************
 // 下载一个文件
 func SingleDownloader(w http.ResponseWriter, r *http.Request) {
-	fileArrs := strings.Split(r.URL.Path, "/")
-	filename := fileArrs[len(fileArrs)-1]
-
-	// 打印文件名
-	fmt.Println("Download: ", filename)
-
-	// 打开文件
-	file, err := os.Open(filepath + "/" + filename)
-	if err != nil {
-		http.Error(w, "Error opening the file", http.StatusInternalServerError)
-		return
-	}
-	defer file.Close()
-
-	// 设置响应头
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-	w.Header().Set("Content-Type", "application/octet-stream")
-
-	// 将文件内容写入响应体
-	io.Copy(w, file)
+	downloadFile(w, r, filepath, pathParam(r, "name"))
 }
 
 // 下载一个结果文件
 func SingleResultDownloader(w http.ResponseWriter, r *http.Request) {
-	fileArrs := strings.Split(r.URL.Path, "/")
-	filename := fileArrs[len(fileArrs)-1]
+	downloadFile(w, r, resultpath, pathParam(r, "name"))
+}
 
+// 下载 root 中的一个文件，支持 Range 断点续传和 If-None-Match / If-Modified-Since 条件请求
+func downloadFile(w http.ResponseWriter, r *http.Request, root, filename string) {
 	// 打印文件名
-	fmt.Println("Download: ", filename)
+	slog.InfoContext(r.Context(), "Download", "file", filename)
+
+	filePath, ok := resolveOrError(w, root, filename)
+	if !ok {
+		return
+	}
 
 	// 打开文件
-	file, err := os.Open(resultpath + "/" + filename)
-	if err != nil {
-		http.Error(w, "Error opening the file", http.StatusInternalServerError)
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		WriteError(w, http.StatusNotFound, "File not found: "+filename, nil)
+		return
+	} else if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error opening the file", err)
 		return
 	}
 	defer file.Close()
 
-	// 设置响应头
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-	w.Header().Set("Content-Type", "application/octet-stream")
+	info, err := file.Stat()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error getting file info", err)
+		return
+	}
+	if info.IsDir() {
+		WriteError(w, http.StatusBadRequest, "Cannot download a folder: "+filename, nil)
+		return
+	}
+
+	// 设置响应头，?inline=true 时在浏览器中直接打开，例如播放视频结果
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "true" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": path.Base(filename)}))
+	w.Header().Set("Content-Type", mimeType(info.Name(), false))
+	w.Header().Set("ETag", fileETag(info))
 
-	// 将文件内容写入响应体
-	io.Copy(w, file)
+	// ServeContent 处理 Range（包括多段 Range）、If-Range、If-None-Match、If-Modified-Since，
+	// 并设置 Last-Modified 和 Content-Length
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// 根据文件大小和修改时间生成强 ETag，文件内容变化时两者至少有一个会变化
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
 }
 
 // ****************************************************  多文件  *****************************************************
 // 下载多个文件，打包成zip文件
 func MultiDownloader(w http.ResponseWriter, r *http.Request) {
 	Cors(w)
-	method := r.Method
+	archiveDownloader(w, r, filepath)
+}
 
-	// 如果是Post请求，下载多个文件
-	if method == http.MethodPost {
-		var requestData struct {
-			Files []string `json:"fileNames"`
-		}
+// 下载多个结果文件，打包成zip文件
+func MultiResultDownloader(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	archiveDownloader(w, r, resultpath)
+}
+
+// 把 root 中的多个文件或文件夹打包，直接写入响应体，不创建临时文件
+// 请求体 {"fileNames": [...], "format": "zip" | "tar.gz"}，fileNames 为空时打包全部文件
+// 也可以通过 ?format=tar.gz 指定格式
+func archiveDownloader(w http.ResponseWriter, r *http.Request, root string) {
+	var requestData struct {
+		Files  []string `json:"fileNames"`
+		Format string   `json:"format"`
+	}
 
-		// 解析请求体
-		err := json.NewDecoder(r.Body).Decode(&requestData)
+	// 解析请求体
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Error parsing request body", err)
+		return
+	}
+	format := requestData.Format
+	if f := r.URL.Query().Get("format"); f != "" {
+		format = f
+	}
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar.gz" {
+		WriteError(w, http.StatusBadRequest, "Unsupported archive format: "+format, nil)
+		return
+	}
+
+	// 没有指定文件时打包全部文件
+	names := requestData.Files
+	if len(names) == 0 {
+		ensureDir(root)
+		entries, err := os.ReadDir(root)
 		if err != nil {
-			http.Error(w, "Error parsing request body: "+err.Error(), http.StatusBadRequest)
+			WriteError(w, http.StatusInternalServerError, "Error reading the folder", err)
 			return
 		}
+		for _, entry := range entries {
+			if !ignoredFile(entry.Name()) {
+				names = append(names, entry.Name())
+			}
+		}
+	}
 
-		// 打印要下载的文件列表
-		fmt.Println("Download files: ", requestData.Files)
-
-		// 创建一个zip文件
-		zipPath := filepath + "/download.zip"
-		zipFile, err := os.Create(zipPath)
-		if err != nil {
-			http.Error(w, "Error creating the zip file", http.StatusInternalServerError)
+	// 在开始写入之前检查所有文件，这样出错时还可以返回JSON错误
+	var sources []archiveSource
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		full, ok := resolveOrError(w, root, name)
+		if !ok {
+			return
+		}
+		if name == "" {
+			WriteError(w, http.StatusBadRequest, "Error: empty file name", nil)
 			return
 		}
-		defer zipFile.Close()
+		if _, err := os.Stat(full); os.IsNotExist(err) {
+			WriteError(w, http.StatusNotFound, "File not found: "+name, nil)
+			return
+		} else if err != nil {
+			WriteError(w, http.StatusInternalServerError, "Error getting file info", err)
+			return
+		}
+		sources = append(sources, archiveSource{name: name, path: full})
+	}
+
+	// 打印要下载的文件列表
+	slog.InfoContext(r.Context(), "Download files", "files", names)
+
+	// 设置响应头
+	archiveName := "download." + format
+	w.Header().Set("Content-Disposition", "attachment; filename="+archiveName)
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		err = writeZip(w, sources)
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		err = writeTarGz(w, sources)
+	}
 
-		// 创建一个zip.Writer
-		zipWriter := zip.NewWriter(zipFile)
+	// 响应头已经发送，只能中断连接让客户端知道下载不完整
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error sending the archive", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
 
-		// 对于每个文件，打开文件，创建zip文件，将文件内容写入zip文件
-		for _, file := range requestData.Files {
-			// 打开文件
-			srcFile, err := os.Open(filepath + "/" + file)
+// 要打包的一个文件或文件夹
+type archiveSource struct {
+	name string // 压缩包中的路径
+	path string // 磁盘上的路径
+}
+
+// 遍历要打包的文件，文件夹会递归遍历，符号链接会被跳过
+func walkSources(sources []archiveSource, fn func(name, full string, info os.FileInfo) error) error {
+	for _, source := range sources {
+		err := fpath.WalkDir(source.path, func(full string, d os.DirEntry, err error) error {
 			if err != nil {
-				http.Error(w, "Error opening the file: "+file, http.StatusInternalServerError)
-				return
+				return err
 			}
-
-			// 创建zip文件条目
-			zipEntry, err := zipWriter.Create(file)
+			if ignoredFile(d.Name()) && full != source.path {
+				if d.IsDir() {
+					return fpath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&os.ModeSymlink != 0 {
+				return nil
+			}
+			info, err := d.Info()
 			if err != nil {
-				http.Error(w, "Error creating the zip entry for file: "+file, http.StatusInternalServerError)
-				return
+				return err
 			}
-
-			// 将文件内容写入zip条目
-			_, err = io.Copy(zipEntry, srcFile)
-			srcFile.Close() // 在每次循环结束时关闭文件
+			rel, err := fpath.Rel(source.path, full)
 			if err != nil {
-				http.Error(w, "Error writing the file: "+file, http.StatusInternalServerError)
-				return
+				return err
 			}
+			return fn(path.Join(source.name, fpath.ToSlash(rel)), full, info)
+		})
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		// 关闭zip.Writer以完成写入
-		err = zipWriter.Close()
+// 把文件打包成zip写入 out
+func writeZip(out io.Writer, sources []archiveSource) error {
+	// 创建一个zip.Writer
+	zipWriter := zip.NewWriter(out)
+
+	// 对于每个文件，创建zip文件条目，将文件内容写入zip条目
+	err := walkSources(sources, func(name, full string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
 		if err != nil {
-			http.Error(w, "Error closing the zip writer", http.StatusInternalServerError)
-			return
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
 		}
 
-		// 打开已完成的zip文件进行读取
-		zipFile, err = os.Open(zipPath)
-		if err != nil {
-			http.Error(w, "Error opening the zip file for reading", http.StatusInternalServerError)
-			return
+		zipEntry, err := zipWriter.CreateHeader(header)
+		if err != nil || info.IsDir() {
+			return err
 		}
-		defer zipFile.Close()
-		defer os.Remove(zipPath)
+		return copyFile(zipEntry, full)
+	})
+	if err != nil {
+		return err
+	}
 
-		// 设置响应头
-		w.Header().Set("Content-Disposition", "attachment; filename=download.zip")
-		w.Header().Set("Content-Type", "application/zip")
+	// 关闭zip.Writer以完成写入
+	return zipWriter.Close()
+}
+
+// 把文件打包成tar.gz写入 out
+func writeTarGz(out io.Writer, sources []archiveSource) error {
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
 
-		// 将zip文件内容写入响应体
-		_, err = io.Copy(w, zipFile)
+	err := walkSources(sources, func(name, full string, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
 		if err != nil {
-			http.Error(w, "Error sending the zip file", http.StatusInternalServerError)
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
 		}
+		if err := tarWriter.WriteHeader(header); err != nil || info.IsDir() {
+			return err
+		}
+		return copyFile(tarWriter, full)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// 将文件内容写入 out
+func copyFile(out io.Writer, full string) error {
+	srcFile, err := os.Open(full)
+	if err != nil {
+		return err
 	}
+	defer srcFile.Close()
+	_, err = io.Copy(out, srcFile)
+	return err
 }
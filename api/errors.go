@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// APIError 是所有接口统一返回的错误格式
+// 例如 {"code": "not_found", "message": "Image not found: nginx", "details": "..."}
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// HTTP状态码对应的错误代码
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "gateway_timeout",
+}
+
+// 得到一个状态码对应的错误代码
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// WriteError 返回一个JSON格式的错误，err 不为空时作为 details 返回
+func WriteError(w http.ResponseWriter, status int, message string, err error) {
+	apiErr := APIError{Code: errorCode(status), Message: message}
+	if err != nil {
+		apiErr.Details = err.Error()
+	}
+
+	// 错误响应不应该带上之前设置的下载头
+	w.Header().Del("Content-Disposition")
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErr)
+}
+
+// 根据docker返回的错误得到对应的HTTP状态码
+func dockerStatus(err error) int {
+	switch {
+	case client.IsErrConnectionFailed(err), errdefs.IsUnavailable(err):
+		return http.StatusBadGateway // docker daemon 无法连接
+	case errdefs.IsNotFound(err):
+		return http.StatusNotFound
+	case errdefs.IsConflict(err):
+		return http.StatusConflict // 例如删除一个正在被容器使用的镜像
+	case errdefs.IsInvalidParameter(err):
+		return http.StatusBadRequest
+	case errdefs.IsUnauthorized(err):
+		return http.StatusUnauthorized
+	case errdefs.IsForbidden(err):
+		return http.StatusForbidden
+	case errdefs.IsDeadline(err):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// 返回一个docker相关的错误，状态码由错误类型决定
+func writeDockerError(w http.ResponseWriter, message string, err error) {
+	WriteError(w, dockerStatus(err), message, err)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"github.com/creack/pty"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// /ws?container=:id&cmd=/bin/bash 在容器中执行命令，而不是在本机启动shell
+// cmd 默认是 /bin/sh，可以带参数，例如 cmd=tail -f /var/log/app.log
+
+// 容器中执行命令时默认的shell，很多精简镜像中没有bash
+const defaultExecShell = "/bin/sh"
+
+// 容器中带TTY的 docker exec 会话
+type execBackend struct {
+	cli    *client.Client
+	execID string
+	resp   types.HijackedResponse
+}
+
+// 解析要执行的命令
+func execCommand(cmd string) []string {
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return []string{defaultExecShell}
+	}
+	return args
+}
+
+// 在容器中创建并连接一个 exec 会话
+func startExecBackend(containerID string, cmd []string, size *pty.Winsize) (*execBackend, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	config := types.ExecConfig{
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          []string{"TERM=xterm-256color"},
+		Cmd:          cmd,
+	}
+	check := types.ExecStartCheck{Tty: true}
+	if size != nil {
+		consoleSize := [2]uint{uint(size.Rows), uint(size.Cols)}
+		config.ConsoleSize = &consoleSize
+		check.ConsoleSize = &consoleSize
+	}
+
+	ctx := context.Background()
+	created, err := cli.ContainerExecCreate(ctx, containerID, config)
+	if err != nil {
+		cli.Close()
+		return nil, err
+	}
+	resp, err := cli.ContainerExecAttach(ctx, created.ID, check)
+	if err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return &execBackend{cli: cli, execID: created.ID, resp: resp}, nil
+}
+
+// 使用TTY时输出没有多路复用，可以直接读取
+func (e *execBackend) Read(b []byte) (int, error)  { return e.resp.Reader.Read(b) }
+func (e *execBackend) Write(b []byte) (int, error) { return e.resp.Conn.Write(b) }
+
+func (e *execBackend) Resize(cols, rows uint16) error {
+	return e.cli.ContainerExecResize(context.Background(), e.execID, container.ResizeOptions{
+		Height: uint(rows),
+		Width:  uint(cols),
+	})
+}
+
+// docker 没有结束 exec 进程的接口，关闭连接后TTY挂断，shell会随之退出
+func (e *execBackend) Close() error {
+	e.resp.Close()
+	return e.cli.Close()
+}
@@ -3,33 +3,38 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
 	"strings"
 )
 
 // get /api/files 是获取所有文件的列表
-// post /api/upload 是上传文件
-// get /api/files/:filename 是下载一个文件
+// post /api/upload 是上传文件, ?dir=project-a 上传到子文件夹
+// get /api/files/:filename 是下载一个文件, 如果是文件夹则返回文件夹中的文件列表, 支持 Range 和 ETag
+// post /api/files/:folder?mkdir 是创建一个文件夹
 // delete /api/files/:filename 是删除一个文件
 // get /api/results 是获取所有结果的列表
-// get /api/results/:resultName 是下载一个结果
+// get /api/results/:resultName 是下载一个结果, 如果是文件夹则返回文件夹中的文件列表
+// post /api/results/:folder?mkdir 是创建一个文件夹
 // delete /api/results/:resultName 是删除一个结果
+// :filename 可以包含子文件夹，例如 /api/files/project-a/input.csv
 // delete /api/files 是批量删除文件
 // delete /api/results 是批量删除结果
-// get /api/files/download 是下载多个文件
+// post /api/files/download 是下载多个文件, 打包成 zip 或 tar.gz
+// post /api/results/download 是下载多个结果, 打包成 zip 或 tar.gz
 
 // cors 跨域请求
 func Cors(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS, HEAD, PATCH")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID, Range, If-Range, If-None-Match, If-Modified-Since, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Total-Count, Location, Content-Range, Content-Length, Accept-Ranges, ETag, Last-Modified, Content-Disposition, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires")
 }
 
-// 定义上传文件和结果文件路径
-const filepath string = "./uploads"
-const resultpath string = "./results"
+// 定义上传文件和结果文件路径，可以在配置文件的 storage 中修改
+var filepath = "./uploads"
+var resultpath = "./results"
 
 // 转换文件大小为人类可读的格式
 func getSize(size int64) string {
@@ -46,157 +51,173 @@ func getSize(size int64) string {
 	return sizeStr
 }
 
-// 获取所有文件的列表，或者批量删除文件
+// 获取所有文件的列表
 func FilesHandler(w http.ResponseWriter, r *http.Request) {
 	// 跨域请求
 	Cors(w)
-	method := r.Method
-
-	// 如果是GET请求，获取所有文件列表
-	if method == http.MethodGet {
-		// 如果files文件夹不存在，创建一个
-		if _, err := os.Stat(filepath); os.IsNotExist(err) {
-			os.Mkdir(filepath, os.ModePerm)
-		}
-		// 如果files文件夹存在，获取文件列表，以数组形式返回
-		files, _ := os.ReadDir(filepath)
-		filesArray := make([]string, 0)
-		for _, file := range files {
-			// 忽略.gitkeep文件 __MACOSX文件夹 .DS_Store文件
-			if file.Name() != ".gitkeep" && file.Name() != "__MACOSX" && file.Name() != ".DS_Store" {
-				filesArray = append(filesArray, file.Name())
-			}
-		}
-		// 返回文件列表
-		json.NewEncoder(w).Encode(filesArray)
-	}
-
-	// 如果是DELETE请求，删除请求体中的文件列表
-	if method == http.MethodDelete {
-		MultiDeleter(w, r)
-	}
+	ListFiles(w, r, filepath, "")
 }
 
 // 获取所有结果的列表
 func ResultsHandler(w http.ResponseWriter, r *http.Request) {
 	// 跨域请求
 	Cors(w)
-	method := r.Method
-
-	// 如果是GET请求，获取所有结果文件列表
-	if method == http.MethodGet {
-		// 如果results文件夹不存在，创建一个
-		if _, err := os.Stat(resultpath); os.IsNotExist(err) {
-			os.Mkdir(resultpath, os.ModePerm)
-		}
-		// 如果results文件夹存在，获取文件列表，以数组形式返回
-		files, _ := os.ReadDir(resultpath)
-		filesArray := make([]string, 0)
-		for _, file := range files {
-			// 忽略.gitkeep文件 __MACOSX文件夹 .DS_Store文件
-			if file.Name() != ".gitkeep" && file.Name() != "__MACOSX" && file.Name() != ".DS_Store" {
-				filesArray = append(filesArray, file.Name())
-			}
-		}
-		// 返回文件列表
-		json.NewEncoder(w).Encode(filesArray)
-	}
-
-	// 如果是DELETE请求，删除请求体中的结果文件
-	if method == http.MethodDelete {
-		MultiResultDeleter(w, r)
+	ListFiles(w, r, resultpath, "")
+}
+
+// 获取 root 中一个文件夹的文件列表，以数组形式返回
+func ListFiles(w http.ResponseWriter, r *http.Request, root, dir string) {
+	// 如果文件夹不存在，创建一个
+	ensureDir(root)
+
+	dirPath, ok := resolveOrError(w, root, dir)
+	if !ok {
+		return
 	}
+	files, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		WriteError(w, http.StatusNotFound, "Folder not found: "+dir, nil)
+		return
+	} else if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error reading the folder", err)
+		return
+	}
+
+	entries, ok := listEntries(w, r, dirPath, dir, files)
+	if !ok {
+		return
+	}
+
+	// ?detail=true 返回详细信息
+	query := r.URL.Query()
+	if query.Get("detail") == "true" || query.Get("digest") == "true" {
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	// 默认只返回文件名数组
+	filesArray := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		filesArray = append(filesArray, entry.Name)
+	}
+	json.NewEncoder(w).Encode(filesArray)
 }
 
-// ************************************************  下载一个文件或删除一个文件  ************************************************
-func FileProcessor(w http.ResponseWriter, r *http.Request) {
-	Cors(w)
-	method := r.Method
+// 在 root 中创建一个文件夹，父文件夹不存在时一起创建
+func CreateFolder(w http.ResponseWriter, r *http.Request, root, dir string) {
+	if dir == "" {
+		WriteError(w, http.StatusBadRequest, "Error: no folder name", nil)
+		return
+	}
+	ensureDir(root)
 
-	// 如果是GET请求，下载文件
-	if method == http.MethodGet {
-		SingleDownloader(w, r)
+	dirPath, ok := resolveOrError(w, root, dir)
+	if !ok {
+		return
+	}
+	if info, err := os.Stat(dirPath); err == nil && !info.IsDir() {
+		WriteError(w, http.StatusConflict, "A file with the same name already exists: "+dir, nil)
+		return
+	}
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating the folder", err)
+		return
 	}
 
-	// 如果是POST请求，对于上传的zip文件，解压并利用 buildpack 创建一个docker image
-	if method == http.MethodPost {
-		ImageBuilder(w, r)
+	slog.InfoContext(r.Context(), "Created folder", "dir", dir)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode("Folder created successfully")
+}
+
+// 判断 root 中的一个路径是不是文件夹
+func isFolder(root, name string) bool {
+	full, err := resolvePath(root, name)
+	if err != nil {
+		return false
 	}
+	info, err := os.Stat(full)
+	return err == nil && info.IsDir()
+}
 
-	// 如果是DELETE请求，删除文件
-	if method == http.MethodDelete {
-		SingleDeleter(w, r)
+// ************************************************  查看或操作一个文件  ************************************************
+// 文件夹返回文件列表，文件则下载；HEAD 请求只返回文件的响应头，例如 Content-Length 和 ETag
+func FileViewer(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	name := pathParam(r, "name")
+	if r.Method == http.MethodGet && isFolder(filepath, name) {
+		ListFiles(w, r, filepath, name)
+		return
 	}
+	SingleDownloader(w, r)
 }
 
-// 下载一个结果或删除一个结果
-func ResultProcessor(w http.ResponseWriter, r *http.Request) {
+// ?mkdir 创建一个文件夹；否则对于上传的zip文件，解压并利用 buildpack 创建一个docker image
+func FileProcessor(w http.ResponseWriter, r *http.Request) {
 	Cors(w)
-	method := r.Method
+	if r.URL.Query().Has("mkdir") {
+		CreateFolder(w, r, filepath, pathParam(r, "name"))
+		return
+	}
+	ImageBuilder(w, r)
+}
 
-	// 如果是GET请求，下载这个结果文件
-	if method == http.MethodGet {
-		SingleResultDownloader(w, r)
+// 文件夹返回结果列表，文件则下载
+func ResultViewer(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	name := pathParam(r, "name")
+	if r.Method == http.MethodGet && isFolder(resultpath, name) {
+		ListFiles(w, r, resultpath, name)
+		return
 	}
+	SingleResultDownloader(w, r)
+}
 
-	// 如果是DELETE请求，删除这个结果文件
-	if method == http.MethodDelete {
-		SingleResultDeleter(w, r)
+// ?mkdir 创建一个文件夹
+func ResultProcessor(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	if !r.URL.Query().Has("mkdir") {
+		WriteError(w, http.StatusBadRequest, "Error: only ?mkdir is supported for results", nil)
+		return
 	}
+	CreateFolder(w, r, resultpath, pathParam(r, "name"))
 }
 
-// 利用 buildpack 创建一个docker image
+// 利用 buildpack 创建一个docker image，构建在后台运行，立即返回构建任务
 func ImageBuilder(w http.ResponseWriter, r *http.Request) {
 	Cors(w)
 
-	// 解析参数
-	params := strings.Split(r.URL.Path, "/")
-	filename := params[len(params)-1]         // 获取docker image 的名称
-	filePosition := filepath + "/" + filename // 找到上传的文件位置
+	// 解析参数，zip文件可以在子文件夹中
+	filename := pathParam(r, "name")
 
 	// 检查文件是否是zip文件, 如果不是则返回错误
 	if !strings.HasSuffix(filename, ".zip") {
-		http.Error(w, "Error: not a zip file", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "Error: not a zip file", nil)
 		return
 	}
 
-	destPosition := filepath + "/" + strings.TrimSuffix(filename, ".zip")
-
-	// 解压文件
-	err := UnzipWithExec(filePosition, filepath)
-	if err != nil {
-		http.Error(w, "Error unzipping file", http.StatusInternalServerError)
+	// 检查文件是否存在
+	filePosition, ok := resolveOrError(w, filepath, filename)
+	if !ok {
+		return
+	}
+	if _, err := os.Stat(filePosition); os.IsNotExist(err) {
+		WriteError(w, http.StatusNotFound, "File not found: "+filename, nil)
 		return
 	}
-	fmt.Println("Unzip success: ", filename)
 
-	// 通过 exec 执行 buildpack 创建docker image
-	trimedName := strings.TrimSuffix(filename, ".zip")
-	lowerName := strings.ToLower(trimedName)
-	cmd := exec.Command("pack", "build", lowerName, "--path", destPosition, "--builder", "paketobuildpacks/builder-jammy-base")
-	output, err := cmd.CombinedOutput()
+	// zip文件名就是镜像名称，需要符合镜像名称的格式
+	image, err := buildImageName(filename)
 	if err != nil {
-		http.Error(w, "Error building image: "+string(output), http.StatusInternalServerError)
+		WriteError(w, http.StatusBadRequest, "Error: the zip file name is not a valid image name", err)
 		return
 	}
 
-	// 删除解压后的文件夹
-	os.RemoveAll(destPosition)
-	fmt.Println("Removed: ", destPosition)
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode("Build success: " + filename)
-}
+	// 创建构建任务
+	build := StartBuild(r.Context(), filename, image)
+	slog.InfoContext(r.Context(), "Build queued", "build", build.Info().Id, "file", filename)
 
-// 用Exec执行unzip命令解压文件
-func UnzipWithExec(filePosition, unzipPosition string) error {
-	cmd := exec.Command("unzip", "-o", filePosition, "-d", unzipPosition)
-	fmt.Println("Trying to unzip file: ", filePosition)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Println("Error unzipping file: ", err)
-		return err
-	}
-	fmt.Println("Unzip success: ", string(output))
-	return nil
+	// 返回构建任务，客户端通过 /api/v1/builds/:id 查询状态
+	w.Header().Set("Location", APIPrefix+"/builds/"+build.Info().Id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(build.Info())
 }
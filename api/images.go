@@ -1,36 +1,48 @@
 package api
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 )
 
 // get /api/images 是获取所有docker images 的列表
 // get /api/images/:imageName 是获取一个docker image 的详细信息
 // post  /api/files/:filename 是上传一个zip文件，解压并利用 buildpack 创建一个docker image
 // delete /api/images/:imageName 是删除一个docker image
+// post /api/pull/:imageName 是拉取一个docker image, ?stream=sse 或 ?stream=ndjson 实时返回每一层的进度
+
+// 创建Docker客户端，images 和 containers 的 handler 共用
+func newDockerClient() (*client.Client, error) {
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+}
 
 // 列出所有docker images
 func ImagesHandler(w http.ResponseWriter, r *http.Request) {
 	// 跨域请求
 	Cors(w)
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := newDockerClient()
 	if err != nil {
-		log.Fatalf("Error creating Docker client: %v", err)
+		writeDockerError(w, "Error creating Docker client", err)
+		return
 	}
 	defer cli.Close()
 
 	// 获取所有docker images
 	images, err := cli.ImageList(r.Context(), image.ListOptions{})
 	if err != nil {
-		log.Fatalf("Error listing Docker images: %v", err)
+		writeDockerError(w, "Error listing Docker images", err)
+		return
 	}
 
 	// 将数据格式化为字符串
@@ -45,27 +57,14 @@ func ImagesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(imageStrs)
 }
 
-// 获取一个docker image 的详细信息
-func ImageProcessor(w http.ResponseWriter, r *http.Request) {
-	// 跨域请求
-	Cors(w)
-	method := r.Method
-
-	// 获取参数
-	params := strings.Split(r.URL.Path, "/")
-	if len(params) < 4 {
-		log.Fatalf("Error: no image name")
-	}
-
-	// 如果是GET请求，获取一个docker image 的详细信息
-	if method == http.MethodGet {
-		ViewImage(w, r)
-	}
-
-	// 如果是DELETE请求，删除一个docker image
-	if method == http.MethodDelete {
-		ImageDeleter(w, r)
+// 获取docker image 的名称，可以包含仓库，例如 /api/v1/images/library/nginx:latest
+func imageParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	imageName := pathParam(r, "name")
+	if imageName == "" {
+		WriteError(w, http.StatusBadRequest, "Error: no image name", nil)
+		return "", false
 	}
+	return imageName, true
 }
 
 // 查看一个docker image 的详细信息
@@ -86,33 +85,30 @@ func ViewImage(w http.ResponseWriter, r *http.Request) {
 		DockerVersion  string   `json:"DockerVersion"`
 	}
 
-	// 获取参数
-	params := strings.Split(r.URL.Path, "/")
-	if len(params) < 4 {
-		log.Fatalf("Error: no image name")
-	}
-
 	// 获取docker image 的名称
-	var imageName string
-	if params[len(params)-2] == "images" {
-		imageName = params[len(params)-1]
-	} else {
-		imageName = params[len(params)-2] + "/" + params[len(params)-1]
+	imageName, ok := imageParam(w, r)
+	if !ok {
+		return
 	}
 
 	// fmt.Println("Inspect Docker image: ", imageName)
 
 	// 获取一个docker image 的详细信息
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := newDockerClient()
 	if err != nil {
-		log.Fatalf("Error creating Docker client: %v", err)
+		writeDockerError(w, "Error creating Docker client", err)
+		return
 	}
 	defer cli.Close()
 
 	// 获取docker image 的详细信息
 	image, _, err := cli.ImageInspectWithRaw(r.Context(), imageName)
-	if err != nil {
-		log.Fatalf("Error inspecting Docker image: %v", err)
+	if client.IsErrNotFound(err) {
+		WriteError(w, http.StatusNotFound, "Image not found: "+imageName, err)
+		return
+	} else if err != nil {
+		writeDockerError(w, "Error inspecting Docker image", err)
+		return
 	}
 
 	// 格式化详细信息
@@ -138,63 +134,54 @@ func ViewImage(w http.ResponseWriter, r *http.Request) {
 
 // 删除一个docker image
 func ImageDeleter(w http.ResponseWriter, r *http.Request) {
-	// 获取参数
-	params := strings.Split(r.URL.Path, "/")
-	if len(params) < 4 {
-		log.Fatalf("Error: no image name")
-	}
+	Cors(w)
 
 	// 获取docker image 的名称
-	var imageName string
-	if params[len(params)-2] == "images" {
-		imageName = params[len(params)-1]
-	} else {
-		imageName = params[len(params)-2] + "/" + params[len(params)-1]
+	imageName, ok := imageParam(w, r)
+	if !ok {
+		return
 	}
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := newDockerClient()
 	if err != nil {
-		log.Fatalf("Error creating Docker client: %v", err)
+		writeDockerError(w, "Error creating Docker client", err)
+		return
 	}
 	defer cli.Close()
 
 	// 删除docker image
 	_, err = cli.ImageRemove(r.Context(), imageName, image.RemoveOptions{})
-	if err != nil {
-		log.Fatalf("Error removing Docker image: %v", err)
+	if client.IsErrNotFound(err) {
+		WriteError(w, http.StatusNotFound, "Image not found: "+imageName, err)
+		return
+	} else if err != nil {
+		// 例如镜像正在被容器使用时返回 409
+		writeDockerError(w, "Error removing Docker image", err)
+		return
 	}
 
 	// 返回删除成功
-	fmt.Println("Delete success: ", imageName)
+	slog.InfoContext(r.Context(), "Image deleted", "image", imageName)
 	json.NewEncoder(w).Encode("Delete success: " + imageName)
 }
 
 // pull 一个docker image
 func ImagePuller(w http.ResponseWriter, r *http.Request) {
 	Cors(w)
-	method := r.Method
 
-	if method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 获取参数
-	params := strings.Split(r.URL.Path, "/")
-	if len(params) < 4 {
-		http.Error(w, "Error: no image name", http.StatusBadRequest)
+	// 获取docker image 的名称
+	imageName, ok := imageParam(w, r)
+	if !ok {
 		return
 	}
 
-	// 获取docker image 的名称
-	imageName := params[len(params)-2] + "/" + params[len(params)-1]
-
-	fmt.Println("Pulling Docker image: ", imageName)
+	slog.InfoContext(r.Context(), "Pulling Docker image", "image", imageName)
+	started := time.Now()
 
 	// 创建Docker客户端
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := newDockerClient()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating Docker client: %v", err), http.StatusInternalServerError)
+		writeDockerError(w, "Error creating Docker client", err)
 		return
 	}
 	defer cli.Close()
@@ -202,24 +189,154 @@ func ImagePuller(w http.ResponseWriter, r *http.Request) {
 	// 拉取Docker镜像，打印输出流
 	out, err := cli.ImagePull(r.Context(), imageName, image.PullOptions{})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error pulling Docker image: %v", err), http.StatusInternalServerError)
+		observePull(started, false)
+		slog.ErrorContext(r.Context(), "Pull failed", "image", imageName, "error", err)
+		writeDockerError(w, "Error pulling Docker image", err)
 		return
 	}
 	defer out.Close()
 
-	// 实时读取输出流并打印到控制台
-	var output strings.Builder
-	scanner := bufio.NewScanner(out)
-	for scanner.Scan() {
-		fmt.Println(scanner.Text()) // 实时打印到控制台
-		output.WriteString(scanner.Text() + "\n")
-	}
-	if err := scanner.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Error reading Docker image pull response: %v", err), http.StatusInternalServerError)
+	// 如果客户端要求流式返回，把每一层的进度实时转发给客户端
+	if mode := pullStreamMode(r); mode != "" {
+		observePull(started, streamPullProgress(r.Context(), w, out, mode, imageName))
 		return
 	}
 
+	// 实时读取输出流并写到调试日志中，docker 在输出流中返回拉取失败的错误，HTTP状态仍然是200
+	decoder := json.NewDecoder(out)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			observePull(started, false)
+			slog.ErrorContext(r.Context(), "Pull failed", "image", imageName, "error", err)
+			writeDockerError(w, "Error reading Docker image pull response", err)
+			return
+		}
+		slog.DebugContext(r.Context(), "Pull progress", "image", imageName, "id", msg.ID, "status", msg.Status)
+		if pullErr := pullMessageError(&msg); pullErr != "" {
+			observePull(started, false)
+			slog.ErrorContext(r.Context(), "Pull failed", "image", imageName, "error", pullErr)
+			WriteError(w, pullErrorStatus(pullErr), "Error pulling Docker image", errors.New(pullErr))
+			return
+		}
+	}
+	observePull(started, true)
+	slog.InfoContext(r.Context(), "Pull success", "image", imageName)
+
 	// 返回成功
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode("Pull success: " + imageName)
 }
+
+// 输出流中一条消息的错误，没有错误时返回空字符串
+func pullMessageError(msg *jsonmessage.JSONMessage) string {
+	if msg.Error != nil {
+		return msg.Error.Message
+	}
+	return msg.ErrorMessage
+}
+
+// 根据拉取失败的错误信息得到HTTP状态码，镜像不存在时是 404，其它错误来自镜像仓库，返回 502
+func pullErrorStatus(message string) int {
+	lower := strings.ToLower(message)
+	for _, notFound := range []string{"not found", "manifest unknown", "repository does not exist"} {
+		if strings.Contains(lower, notFound) {
+			return http.StatusNotFound
+		}
+	}
+	return http.StatusBadGateway
+}
+
+// PullProgress 是拉取镜像时每一层的进度
+type PullProgress struct {
+	Id      string `json:"id,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// 判断客户端需要的流式格式: ?stream=sse 或 ?stream=ndjson, 也可以通过 Accept 头指定
+func pullStreamMode(r *http.Request) string {
+	switch r.URL.Query().Get("stream") {
+	case "sse":
+		return "sse"
+	case "ndjson":
+		return "ndjson"
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "text/event-stream") {
+		return "sse"
+	}
+	if strings.Contains(accept, "application/x-ndjson") {
+		return "ndjson"
+	}
+	return ""
+}
+
+// 把docker pull 的输出流转换为 SSE 或 NDJSON 发送给客户端，返回是否拉取成功
+func streamPullProgress(ctx context.Context, w http.ResponseWriter, out io.Reader, mode, imageName string) bool {
+	if mode == "sse" {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // 关闭 nginx 的缓冲
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	// 发送一个事件
+	send := func(event string, data interface{}) error {
+		payload, _ := json.Marshal(data)
+		var err error
+		if mode == "sse" {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", payload)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	}
+
+	// docker 返回的每一行都是一个 jsonmessage
+	var pullErr string
+	decoder := json.NewDecoder(out)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			pullErr = fmt.Sprintf("Error reading Docker image pull response: %v", err)
+			break
+		}
+
+		progress := PullProgress{Id: msg.ID, Status: msg.Status}
+		if msg.Progress != nil {
+			progress.Current = msg.Progress.Current
+			progress.Total = msg.Progress.Total
+		}
+		progress.Error = pullMessageError(&msg)
+		if progress.Error != "" {
+			pullErr = progress.Error
+		}
+		if err := send("progress", progress); err != nil {
+			// 客户端已经断开
+			return false
+		}
+	}
+
+	// 发送最终结果
+	if pullErr != "" {
+		slog.ErrorContext(ctx, "Pull failed", "image", imageName, "error", pullErr)
+		send("error", PullProgress{Status: "Pull failed: " + imageName, Error: pullErr})
+		return false
+	}
+	slog.InfoContext(ctx, "Pull success", "image", imageName)
+	send("done", PullProgress{Status: "Pull success: " + imageName})
+	return true
+}
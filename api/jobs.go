@@ -0,0 +1,280 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	fpath "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// post /api/jobs 是用一个docker image 处理上传的文件，输出保存到 ./results/:resultName
+
+// 运行任务时使用的临时工作目录，任务结束后输出会被移动到 ./results
+var jobpath = "./jobs"
+
+// 请求结束后删除容器的超时时间，客户端断开时请求的 context 已经取消，删除使用单独的 context
+const jobCleanupTimeout = 30 * time.Second
+
+// JobRequest 是运行任务时的请求体
+type JobRequest struct {
+	Image     string   `json:"image"`     // 来自 /api/images 的 docker image 名称
+	Files     []string `json:"fileNames"` // ./uploads 中的文件名，可以包含子文件夹
+	Cmd       []string `json:"cmd"`       // 可选，覆盖 image 默认的命令
+	Env       []string `json:"env"`       // 可选，额外的环境变量
+	InputDir  string   `json:"inputDir"`  // 容器内的输入目录，默认 /input
+	OutputDir string   `json:"outputDir"` // 容器内的输出目录，默认 /output
+}
+
+// JobResult 是任务完成后返回的结果
+type JobResult struct {
+	ContainerId string   `json:"ContainerId"`
+	Image       string   `json:"Image"`
+	Result      string   `json:"Result"` // ./results 中的结果文件夹名称
+	ExitCode    int64    `json:"ExitCode"`
+	Files       []string `json:"Files"`
+	Logs        string   `json:"Logs"`
+	Duration    string   `json:"Duration"`
+}
+
+// 运行一个任务：用一个docker image 处理上传的文件，把文件挂载到容器的输入目录，等待容器退出，把输出目录移动到 ./results
+func RunJob(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+
+	// 解析请求体
+	var requestData JobRequest
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Error parsing request body", err)
+		return
+	}
+	if requestData.Image == "" {
+		WriteError(w, http.StatusBadRequest, "Error: no image name", nil)
+		return
+	}
+	if requestData.InputDir == "" {
+		requestData.InputDir = "/input"
+	}
+	if requestData.OutputDir == "" {
+		requestData.OutputDir = "/output"
+	}
+
+	// 每个输入文件以只读方式挂载到输入目录下
+	var mounts []mount.Mount
+	for _, file := range requestData.Files {
+		file = strings.Trim(file, "/")
+		if file == "" {
+			WriteError(w, http.StatusBadRequest, "Error: no file name", nil)
+			return
+		}
+		filePath, ok := resolveOrError(w, filepath, file)
+		if !ok {
+			return
+		}
+		source, err := fpath.Abs(filePath)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "Error resolving file path", err)
+			return
+		}
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			WriteError(w, http.StatusNotFound, "File or folder not found: "+file, nil)
+			return
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   source,
+			Target:   path.Join(requestData.InputDir, file),
+			ReadOnly: true,
+		})
+	}
+
+	// 创建一个新的输出目录
+	if err := os.MkdirAll(jobpath, os.ModePerm); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating the job folder", err)
+		return
+	}
+	outputPath, err := os.MkdirTemp(jobpath, "output-")
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating the output folder", err)
+		return
+	}
+	// 如果任务失败，输出目录不会被移动，需要清理
+	defer os.RemoveAll(outputPath)
+	// 容器内的进程可能不是root用户，需要可以写入
+	os.Chmod(outputPath, 0o777)
+
+	outputSource, err := fpath.Abs(outputPath)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error resolving output path", err)
+		return
+	}
+	mounts = append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: outputSource,
+		Target: requestData.OutputDir,
+	})
+
+	cli, err := newDockerClient()
+	if err != nil {
+		writeDockerError(w, "Error creating Docker client", err)
+		return
+	}
+	defer cli.Close()
+
+	// 创建容器
+	env := append([]string{"INPUT_DIR=" + requestData.InputDir, "OUTPUT_DIR=" + requestData.OutputDir}, requestData.Env...)
+	config := &container.Config{
+		Image:  requestData.Image,
+		Cmd:    requestData.Cmd,
+		Env:    env,
+		Labels: map[string]string{"upc.job": "true"},
+	}
+	hostConfig := &container.HostConfig{Mounts: mounts}
+
+	slog.InfoContext(r.Context(), "Running job", "image", requestData.Image, "files", requestData.Files)
+	start := time.Now()
+
+	created, err := cli.ContainerCreate(r.Context(), config, hostConfig, nil, nil, "")
+	if client.IsErrNotFound(err) {
+		WriteError(w, http.StatusNotFound, "Image not found: "+requestData.Image, nil)
+		return
+	} else if err != nil {
+		writeDockerError(w, "Error creating Docker container", err)
+		return
+	}
+	// 任务结束后删除容器，容器还在运行时强制删除会先停止它
+	// 这个 defer 在删除输出目录之前执行，不会在容器运行时删除它挂载的目录
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), jobCleanupTimeout)
+		defer cancel()
+		if err := cli.ContainerRemove(ctx, created.ID, container.RemoveOptions{Force: true}); err != nil {
+			slog.ErrorContext(ctx, "Failed to remove job container", "container", created.ID, "error", err)
+		}
+	}()
+
+	// 先开始等待再启动容器，避免错过退出事件
+	statusCh, errCh := cli.ContainerWait(r.Context(), created.ID, container.WaitConditionNextExit)
+	if err := cli.ContainerStart(r.Context(), created.ID, container.StartOptions{}); err != nil {
+		writeDockerError(w, "Error starting Docker container", err)
+		return
+	}
+
+	// 等待容器退出，客户端断开时 errCh 返回 context 的错误，容器由上面的 defer 停止并删除
+	var exitCode int64
+	select {
+	case err := <-errCh:
+		if r.Context().Err() != nil {
+			slog.WarnContext(r.Context(), "Job aborted, client disconnected", "container", created.ID)
+			return
+		}
+		writeDockerError(w, "Error waiting for Docker container", err)
+		return
+	case status := <-statusCh:
+		exitCode = status.StatusCode
+	}
+
+	// 获取容器的输出
+	var logs bytes.Buffer
+	logReader, err := cli.ContainerLogs(r.Context(), created.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err == nil {
+		stdcopy.StdCopy(&logs, &logs, logReader)
+		logReader.Close()
+	}
+
+	// 把日志也保存到输出目录
+	os.WriteFile(outputPath+"/job.log", logs.Bytes(), 0o644)
+
+	// 移动输出目录到 ./results
+	if err := os.MkdirAll(resultpath, os.ModePerm); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating the results folder", err)
+		return
+	}
+	resultName, err := jobResultName(requestData.Image)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating the result folder", err)
+		return
+	}
+	if err := moveDir(outputPath, resultpath+"/"+resultName); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error moving the job output", err)
+		return
+	}
+
+	// 列出输出的文件
+	files, _ := os.ReadDir(resultpath + "/" + resultName)
+	filesArray := make([]string, 0)
+	for _, file := range files {
+		filesArray = append(filesArray, file.Name())
+	}
+
+	slog.InfoContext(r.Context(), "Job finished", "result", resultName, "exitCode", exitCode)
+	json.NewEncoder(w).Encode(JobResult{
+		ContainerId: created.ID,
+		Image:       requestData.Image,
+		Result:      resultName,
+		ExitCode:    exitCode,
+		Files:       filesArray,
+		Logs:        logs.String(),
+		Duration:    time.Since(start).Round(time.Millisecond).String(),
+	})
+}
+
+// 创建一个空的结果文件夹并返回名称，例如 paketo-demo-latest-20240601-150405
+// os.Mkdir 在文件夹已经存在时失败，同时运行的任务不会得到同一个名称
+func jobResultName(imageName string) (string, error) {
+	replacer := strings.NewReplacer("/", "-", ":", "-", "@", "-")
+	base := replacer.Replace(imageName) + "-" + time.Now().Format("20060102-150405")
+
+	// 如果同名文件夹已经存在，添加序号
+	name := base
+	for i := 1; ; i++ {
+		err := os.Mkdir(resultpath+"/"+name, os.ModePerm)
+		if err == nil {
+			return name, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// 把 src 中的内容移动到已经存在的空文件夹 dst
+// 先尝试直接重命名，不在同一个文件系统或者系统不允许替换文件夹时逐个移动文件
+func moveDir(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	return fpath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := fpath.Rel(src, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := fpath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case d.Type().IsRegular():
+			return moveFile(p, target)
+		default:
+			// 符号链接等特殊文件不复制
+			slog.Warn("Skipping special file in job output", "file", rel)
+			return nil
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	fpath "path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// get /api/files 和 get /api/results 支持的查询参数:
+//   ?detail=true              返回每个文件的详细信息, 默认只返回文件名数组
+//   ?digest=true              同时返回 SHA-256, 第一次计算后会缓存
+//   ?sort=name|size|time|type 排序字段, ?order=desc 倒序
+//   ?glob=*.csv               按文件名通配符过滤
+//   ?ext=csv,png              按扩展名过滤
+//   ?page=1&pageSize=100      分页, pageSize 最大 1000, 总数在 X-Total-Count 响应头中返回
+
+// 每页最多返回的文件数量，更大的 pageSize 按这个值处理
+const maxPageSize = 1000
+
+// FileEntry 是文件列表中一个文件的详细信息
+type FileEntry struct {
+	Name      string `json:"Name"`
+	Path      string `json:"Path"` // 相对于 ./uploads 或 ./results 的路径
+	Size      int64  `json:"Size"`
+	SizeHuman string `json:"SizeHuman"`
+	ModTime   string `json:"ModTime"`
+	IsDir     bool   `json:"IsDir"`
+	MimeType  string `json:"MimeType"`
+	Sha256    string `json:"Sha256,omitempty"`
+}
+
+// 缓存的 SHA-256，文件大小或修改时间变化后重新计算
+type digestEntry struct {
+	key     string
+	size    int64
+	modTime time.Time
+	digest  string
+}
+
+// 最多缓存的文件数量，超过时删除最久没有使用的，已经删除的文件不会一直留在缓存中
+const maxDigestEntries = 10000
+
+var (
+	digestMu    sync.Mutex
+	digestCache = make(map[string]*list.Element) // 路径 -> digestLRU 中的 *digestEntry
+	digestLRU   = list.New()                     // 最近使用的在前面
+)
+
+// 计算一个文件的 SHA-256，结果按路径缓存
+func fileDigest(filePath string, info os.FileInfo) (string, error) {
+	key, err := fpath.Abs(filePath)
+	if err != nil {
+		key = filePath
+	}
+
+	digestMu.Lock()
+	if el, ok := digestCache[key]; ok {
+		cached := el.Value.(*digestEntry)
+		if cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+			digestLRU.MoveToFront(el)
+			digestMu.Unlock()
+			return cached.digest, nil
+		}
+	}
+	digestMu.Unlock()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	entry := &digestEntry{key: key, size: info.Size(), modTime: info.ModTime(), digest: digest}
+	digestMu.Lock()
+	if el, ok := digestCache[key]; ok {
+		el.Value = entry
+		digestLRU.MoveToFront(el)
+	} else {
+		digestCache[key] = digestLRU.PushFront(entry)
+	}
+	for digestLRU.Len() > maxDigestEntries {
+		oldest := digestLRU.Back()
+		digestLRU.Remove(oldest)
+		delete(digestCache, oldest.Value.(*digestEntry).key)
+	}
+	digestMu.Unlock()
+	return digest, nil
+}
+
+// 根据扩展名判断 MIME 类型
+func mimeType(name string, isDir bool) string {
+	if isDir {
+		return "inode/directory"
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// 判断文件是否符合 ?glob 和 ?ext 过滤条件
+func matchFilters(name, glob string, exts []string) bool {
+	if glob != "" {
+		if ok, err := path.Match(glob, name); err != nil || !ok {
+			return false
+		}
+	}
+	if len(exts) > 0 {
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+		for _, e := range exts {
+			if ext == e {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// 读取文件夹中的文件信息，应用过滤、排序和分页
+func listEntries(w http.ResponseWriter, r *http.Request, dirPath, dir string, entries []os.DirEntry) ([]FileEntry, bool) {
+	query := r.URL.Query()
+	glob := query.Get("glob")
+	var exts []string
+	for _, e := range strings.Split(query.Get("ext"), ",") {
+		if e = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), ".")); e != "" {
+			exts = append(exts, e)
+		}
+	}
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid glob pattern: "+glob, err)
+			return nil, false
+		}
+	}
+
+	// 收集文件信息
+	files := make([]FileEntry, 0, len(entries))
+	infos := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		// 忽略.gitkeep文件 __MACOSX文件夹 .DS_Store文件
+		if ignoredFile(name) || !matchFilters(name, glob, exts) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// 文件在读取过程中被删除
+			continue
+		}
+		infos[name] = info
+		files = append(files, FileEntry{
+			Name:      name,
+			Path:      path.Join(dir, name),
+			Size:      info.Size(),
+			SizeHuman: getSize(info.Size()),
+			ModTime:   info.ModTime().Format(time.RFC3339),
+			IsDir:     info.IsDir(),
+			MimeType:  mimeType(name, info.IsDir()),
+		})
+	}
+
+	// 排序
+	desc := query.Get("order") == "desc"
+	var less func(a, b FileEntry) bool
+	switch query.Get("sort") {
+	case "", "name":
+		less = func(a, b FileEntry) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b FileEntry) bool { return a.Size < b.Size }
+	case "time":
+		less = func(a, b FileEntry) bool { return infos[a.Name].ModTime().Before(infos[b.Name].ModTime()) }
+	case "type":
+		less = func(a, b FileEntry) bool {
+			if a.MimeType != b.MimeType {
+				return a.MimeType < b.MimeType
+			}
+			return a.Name < b.Name
+		}
+	default:
+		WriteError(w, http.StatusBadRequest, "Invalid sort field: "+query.Get("sort"), nil)
+		return nil, false
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if desc {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+
+	// 分页
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(files)))
+	if query.Has("page") || query.Has("pageSize") {
+		page, err1 := strconv.Atoi(query.Get("page"))
+		pageSize, err2 := strconv.Atoi(query.Get("pageSize"))
+		if !query.Has("page") {
+			page, err1 = 1, nil
+		}
+		if !query.Has("pageSize") {
+			pageSize, err2 = 100, nil
+		}
+		if err1 != nil || err2 != nil || page < 1 || pageSize < 1 {
+			WriteError(w, http.StatusBadRequest, "Invalid page or pageSize", nil)
+			return nil, false
+		}
+		// 先比较页码再相乘，很大的 page 或 pageSize 不会溢出
+		pageSize = min(pageSize, maxPageSize)
+		if page-1 > len(files)/pageSize {
+			files = files[:0]
+		} else {
+			start := (page - 1) * pageSize
+			end := min(start+pageSize, len(files))
+			files = files[min(start, len(files)):end]
+		}
+	}
+
+	// 只为当前页的文件计算 SHA-256
+	if query.Get("digest") == "true" {
+		for i := range files {
+			if files[i].IsDir {
+				continue
+			}
+			digest, err := fileDigest(dirPath+"/"+files[i].Name, infos[files[i].Name])
+			if err == nil {
+				files[i].Sha256 = digest
+			}
+		}
+	}
+
+	return files, true
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"UPC-GO/metrics"
+	"UPC-GO/register"
+	"io/fs"
+	fpath "path/filepath"
+	"sync"
+	"time"
+)
+
+// get /metrics 输出的业务指标，HTTP 请求的指标见 metrics.Instrument
+var (
+	buildsTotal = metrics.NewCounterVec("upc_builds_total",
+		"Finished image builds by outcome (succeeded, failed, canceled).", "status")
+	buildDuration = metrics.NewHistogramVec("upc_build_duration_seconds",
+		"Image build duration from start to finish by outcome.", metrics.LongBuckets, "status")
+	pullsTotal = metrics.NewCounterVec("upc_image_pulls_total",
+		"Docker image pulls by outcome (success, failure).", "status")
+	pullDuration = metrics.NewHistogramVec("upc_image_pull_duration_seconds",
+		"Docker image pull duration by outcome.", metrics.LongBuckets, "status")
+	terminalSessions = metrics.NewGaugeVec("upc_terminal_sessions",
+		"Terminal sessions currently alive, by backend (host, container).", "backend")
+	terminalClients = metrics.NewGaugeVec("upc_terminal_clients",
+		"WebSocket clients currently attached to terminal sessions.")
+	storageUsed = metrics.NewGaugeVec("upc_storage_used_bytes",
+		"Bytes used by files in a storage directory.", "dir")
+	storageFiles = metrics.NewGaugeVec("upc_storage_files",
+		"Number of files in a storage directory.", "dir")
+	storageAvailable = metrics.NewGaugeVec("upc_storage_available_bytes",
+		"Bytes available on the filesystem of a storage directory.", "dir")
+)
+
+func init() {
+	metrics.OnScrape(collectTerminalMetrics)
+	metrics.OnScrape(collectStorageMetrics)
+}
+
+// 记录一个结束的构建
+func observeBuild(status string, started time.Time) {
+	buildsTotal.Inc(status)
+	// 排队时就被取消的构建没有开始时间
+	if !started.IsZero() {
+		buildDuration.Observe(time.Since(started).Seconds(), status)
+	}
+}
+
+// 记录一次镜像拉取
+func observePull(started time.Time, success bool) {
+	status := "success"
+	if !success {
+		status = "failure"
+	}
+	pullsTotal.Inc(status)
+	pullDuration.Observe(time.Since(started).Seconds(), status)
+}
+
+// 统计终端会话和客户端数量
+func collectTerminalMetrics() {
+	sessionsMu.Lock()
+	all := make([]*TerminalSession, 0, len(sessions))
+	for _, s := range sessions {
+		all = append(all, s)
+	}
+	sessionsMu.Unlock()
+
+	host, container, clients := 0, 0, 0
+	for _, s := range all {
+		info := s.Info()
+		if info.Container != "" {
+			container++
+		} else {
+			host++
+		}
+		clients += info.Clients
+	}
+	terminalSessions.Set(float64(host), "host")
+	terminalSessions.Set(float64(container), "container")
+	terminalClients.Set(float64(clients))
+}
+
+// 遍历目录很慢，结果缓存一段时间
+const storageMetricsTTL = 30 * time.Second
+
+var (
+	storageMu      sync.Mutex
+	storageScanned time.Time
+)
+
+// 统计 uploads 和 results 目录的使用情况
+func collectStorageMetrics() {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	if time.Since(storageScanned) < storageMetricsTTL {
+		return
+	}
+	storageScanned = time.Now()
+
+	for name, dir := range map[string]string{"uploads": filepath, "results": resultpath} {
+		var size, files int64
+		fpath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+				files++
+			}
+			return nil
+		})
+		storageUsed.Set(float64(size), name)
+		storageFiles.Set(float64(files), name)
+		if disk, err := register.GetDiskUsage(dir); err == nil {
+			storageAvailable.Set(float64(disk.Available), name)
+		}
+	}
+}
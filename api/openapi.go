@@ -0,0 +1,30 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// get /api/v1/openapi.json 是接口的 OpenAPI 3 文档，修改或新增接口时需要同时更新 openapi.json
+// get /api/v1/docs 是查看文档的页面，不依赖外部资源
+// openapi_test.go 检查 Routes 注册的每个接口都出现在文档中
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// 返回 OpenAPI 文档
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(openAPISpec)
+}
+
+// 返回文档页面，页面从同一目录下的 openapi.json 读取文档
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
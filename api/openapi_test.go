@@ -0,0 +1,150 @@
+package api_test
+
+import (
+	"UPC-GO/api"
+	"UPC-GO/registry"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// OpenAPI 文档中的一个接口
+type specOperation struct {
+	OperationID string `json:"operationId"`
+}
+
+// 注册所有接口的路由，包括注册中心模式的接口
+func newTestRouter() *api.Router {
+	rt := api.NewRouter()
+	api.Routes(rt)
+	registry.New(time.Minute, "test-token", nil).Register(rt)
+	return rt
+}
+
+// 从服务器读取 OpenAPI 文档，返回 路径 -> 方法 -> 接口
+func loadSpec(t *testing.T, rt *api.Router) map[string]map[string]specOperation {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, api.APIPrefix+"/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET openapi.json: status %d", rec.Code)
+	}
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Fatalf("openapi version = %q, want 3.x", spec.OpenAPI)
+	}
+
+	paths := make(map[string]map[string]specOperation)
+	for path, item := range spec.Paths {
+		paths[path] = make(map[string]specOperation)
+		for key, raw := range item {
+			// 路径级别的 parameters 等不是接口
+			if !isSpecMethod(key) {
+				continue
+			}
+			var op specOperation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				t.Fatalf("%s %s: %v", key, path, err)
+			}
+			paths[path][key] = op
+		}
+	}
+	return paths
+}
+
+func isSpecMethod(key string) bool {
+	switch key {
+	case "get", "head", "post", "put", "patch", "delete", "options":
+		return true
+	}
+	return false
+}
+
+// {name...} 在 OpenAPI 中写作 {name}
+var wildcard = regexp.MustCompile(`\{(\w+)\.\.\.\}`)
+
+func specPath(pattern string) string {
+	return wildcard.ReplaceAllString(strings.TrimSuffix(pattern, "{$}"), "{$1}")
+}
+
+// 每个注册的接口都需要出现在 OpenAPI 文档中
+func TestOpenAPICoversRoutes(t *testing.T) {
+	rt := newTestRouter()
+	spec := loadSpec(t, rt)
+
+	for _, route := range rt.Routes() {
+		// 旧路径是 /api/v1 的别名，文档中只写新路径
+		if route.Legacy {
+			continue
+		}
+		path := specPath(route.Path)
+		item, ok := spec[path]
+		if !ok {
+			t.Errorf("route %s %s is missing from openapi.json (path %s)", route.Method, route.Path, path)
+			continue
+		}
+		// 没有方法的路由匹配所有方法，例如转发到节点
+		if route.Method == "" {
+			if len(item) == 0 {
+				t.Errorf("route %s has no operations in openapi.json", route.Path)
+			}
+			continue
+		}
+		if _, ok := item[strings.ToLower(route.Method)]; !ok {
+			t.Errorf("route %s %s is missing from openapi.json", route.Method, route.Path)
+		}
+	}
+}
+
+// 文档中的每个接口都需要有对应的路由，operationId 不能重复
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	rt := newTestRouter()
+	spec := loadSpec(t, rt)
+
+	// 注册的路径 -> 方法
+	registered := make(map[string]map[string]bool)
+	for _, route := range rt.Routes() {
+		path := specPath(route.Path)
+		if registered[path] == nil {
+			registered[path] = make(map[string]bool)
+		}
+		registered[path][route.Method] = true
+	}
+
+	ids := make(map[string]string)
+	for path, item := range spec {
+		methods, ok := registered[path]
+		if !ok {
+			t.Errorf("openapi.json documents %s, which is not registered", path)
+			continue
+		}
+		for method, op := range item {
+			upper := strings.ToUpper(method)
+			// GET 也处理 HEAD，Router 会响应所有路由的 OPTIONS 预检
+			served := methods[upper] || methods[""] ||
+				(upper == http.MethodHead && methods[http.MethodGet]) || upper == http.MethodOptions
+			if !served {
+				t.Errorf("openapi.json documents %s %s, which is not registered", upper, path)
+			}
+
+			if op.OperationID == "" {
+				t.Errorf("%s %s has no operationId", upper, path)
+			} else if other, ok := ids[op.OperationID]; ok {
+				t.Errorf("operationId %s is used by %s and %s %s", op.OperationID, other, upper, path)
+			}
+			ids[op.OperationID] = upper + " " + path
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	fpath "path/filepath"
+	"strings"
+)
+
+// 所有文件相关的接口都通过 resolvePath 把客户端给的相对路径转换为磁盘路径，
+// 保证结果一定在 ./uploads 或 ./results 之内
+
+// 路径不合法，例如包含 ".." 或者通过符号链接指向存储目录之外
+var errInvalidPath = errors.New("invalid path")
+
+// 把相对路径 name 安全地解析到 root 目录下，name 为空时返回 root 本身
+func resolvePath(root, name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", errInvalidPath
+	}
+
+	// 统一使用 / 作为分隔符，不允许任何 ".." 路径段
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", errInvalidPath
+		}
+	}
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	full := fpath.Join(root, fpath.FromSlash(cleaned))
+
+	// 检查已存在的部分有没有通过符号链接跳出 root
+	realRoot, err := fpath.EvalSymlinks(root)
+	if err != nil {
+		// root 还不存在时不可能有符号链接
+		return full, nil
+	}
+	existing := full
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := fpath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	realExisting, err := fpath.EvalSymlinks(existing)
+	if err != nil {
+		return "", errInvalidPath
+	}
+	rel, err := fpath.Rel(realRoot, realExisting)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(fpath.Separator)) {
+		return "", errInvalidPath
+	}
+
+	return full, nil
+}
+
+// 取出路由中的路径参数，例如 /api/v1/files/{name...} 中的 project-a/input.csv
+func pathParam(r *http.Request, name string) string {
+	return strings.Trim(r.PathValue(name), "/")
+}
+
+// 解析路径，不合法时直接返回 400
+func resolveOrError(w http.ResponseWriter, root, name string) (string, bool) {
+	full, err := resolvePath(root, name)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid path: "+name, err)
+		return "", false
+	}
+	return full, true
+}
+
+// 列表中需要忽略的文件
+func ignoredFile(name string) bool {
+	return name == ".gitkeep" || name == "__MACOSX" || name == ".DS_Store"
+}
+
+// 如果存储目录不存在，创建一个
+func ensureDir(root string) {
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		os.MkdirAll(root, os.ModePerm)
+	}
+}
@@ -0,0 +1,14 @@
+package api
+
+import (
+	"UPC-GO/register"
+	"encoding/json"
+	"net/http"
+)
+
+// get /api/registration 获取本节点在注册中心的注册状态和心跳配置
+func RegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+	json.NewEncoder(w).Encode(register.Status())
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// 所有接口都挂载在 /api/v1 下，旧的 /api 路径作为兼容的别名保留
+const APIPrefix = "/api/v1"
+
+// 旧的接口前缀
+const legacyPrefix = "/api"
+
+// 路由时检查的方法，用来生成 405 和 OPTIONS 响应中的 Allow
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// Route 是一个注册了的路由
+type Route struct {
+	Method string // 为空时匹配所有方法
+	Path   string // 例如 /api/v1/files/{name...}
+	Legacy bool   // 是不是旧路径的别名
+}
+
+// Router 使用 Go 1.22 的 "METHOD /path/{param}" 路由，在 http.ServeMux 之上补充:
+// 路径存在但方法不对时返回 405 和 Allow 头，没有注册 OPTIONS 的路由自动响应跨域预检，
+// 404 和 405 和其它接口一样返回 JSON 错误
+type Router struct {
+	mux    *http.ServeMux
+	routes []Route
+}
+
+// NewRouter 创建一个空的路由
+func NewRouter() *Router {
+	return &Router{mux: http.NewServeMux()}
+}
+
+// Handle 注册一个路由，pattern 是 "GET /metrics" 这样的格式，没有方法时匹配所有方法
+func (rt *Router) Handle(pattern string, handler http.Handler) {
+	rt.handle(pattern, handler, false)
+}
+
+// HandleFunc 注册一个处理函数
+func (rt *Router) HandleFunc(pattern string, handler http.HandlerFunc) {
+	rt.handle(pattern, handler, false)
+}
+
+// API 在 /api/v1 和旧的 /api 下同时注册一个接口，pattern 中的路径不带前缀，例如 "GET /files/{name...}"
+func (rt *Router) API(pattern string, handler http.HandlerFunc) {
+	method, path := splitPattern(pattern)
+	rt.handle(joinPattern(method, APIPrefix+path), handler, false)
+	rt.handle(joinPattern(method, legacyPrefix+path), handler, true)
+}
+
+func (rt *Router) handle(pattern string, handler http.Handler, legacy bool) {
+	rt.mux.Handle(pattern, handler)
+	method, path := splitPattern(pattern)
+	rt.routes = append(rt.routes, Route{Method: method, Path: path, Legacy: legacy})
+}
+
+// Routes 返回所有注册了的路由，按路径排序
+func (rt *Router) Routes() []Route {
+	routes := append([]Route(nil), rt.routes...)
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	return routes
+}
+
+// Handler 返回请求对应的处理函数和路由，没有匹配的路由或者只会被重定向时 pattern 为空
+func (rt *Router) Handler(r *http.Request) (http.Handler, string) {
+	h, pattern := rt.mux.Handler(r)
+	if reflect.TypeOf(h) == redirectHandlerType {
+		pattern = ""
+	}
+	return h, pattern
+}
+
+// ServeMux 自动添加斜杠或者清理路径时返回的处理函数类型
+var redirectHandlerType = reflect.TypeOf(http.RedirectHandler("/", http.StatusMovedPermanently))
+
+// 把 /api/v1 下的路径转换为旧的 /api 路径，两者使用相同的权限
+func legacyPath(p string) string {
+	if p == APIPrefix || strings.HasPrefix(p, APIPrefix+"/") {
+		return legacyPrefix + strings.TrimPrefix(p, APIPrefix)
+	}
+	return p
+}
+
+// 把 "GET /files" 分成方法和路径
+func splitPattern(pattern string) (string, string) {
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		return method, strings.TrimSpace(path)
+	}
+	return "", pattern
+}
+
+func joinPattern(method, path string) string {
+	if method == "" {
+		return path
+	}
+	return method + " " + path
+}
+
+// 请求匹配的路由，ServeMux 把 /files 重定向到 /files/ 时不算匹配，
+// 这时返回的 pattern 是重定向后的路由，例如 POST /files/{name...}
+// 返回匹配的 pattern 和是否会被重定向
+func (rt *Router) match(r *http.Request) (string, bool) {
+	h, pattern := rt.mux.Handler(r)
+	if pattern != "" && reflect.TypeOf(h) == redirectHandlerType {
+		return "", true
+	}
+	return pattern, false
+}
+
+// 路径对应的所有方法，只算真正处理这个路径的路由
+func (rt *Router) allowedMethods(r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := *r
+		probe.Method = method
+		if pattern, _ := rt.match(&probe); pattern != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pattern, redirect := rt.match(r)
+	if pattern != "" {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+
+	Cors(w)
+	allowed := rt.allowedMethods(r)
+	if len(allowed) == 0 {
+		if redirect {
+			// 路径只有带斜杠的版本，例如 /static
+			rt.mux.ServeHTTP(w, r)
+			return
+		}
+		WriteError(w, http.StatusNotFound, "Not found: "+r.URL.Path, nil)
+		return
+	}
+
+	// 没有单独处理 OPTIONS 的路由，响应跨域预检
+	if !slices.Contains(allowed, http.MethodOptions) {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	methods := strings.Join(allowed, ", ")
+	w.Header().Set("Allow", methods)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	WriteError(w, http.StatusMethodNotAllowed, "Method not allowed: "+r.Method+" "+r.URL.Path, nil)
+}
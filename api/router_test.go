@@ -0,0 +1,61 @@
+package api_test
+
+import (
+	"UPC-GO/api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 列表路径的子路径 {name...} 接受更多的方法，ServeMux 会把列表路径重定向到子路径，
+// 这些方法在列表路径上应该返回 405，Allow 只包含列表路径自己的方法
+func TestCollectionMethods(t *testing.T) {
+	rt := newTestRouter()
+	tests := []struct {
+		method, path string
+		code         int
+		allow        string
+	}{
+		{http.MethodPost, "/api/v1/files", http.StatusMethodNotAllowed, "GET, HEAD, DELETE, OPTIONS"},
+		{http.MethodPost, "/api/files", http.StatusMethodNotAllowed, "GET, HEAD, DELETE, OPTIONS"},
+		{http.MethodPost, "/api/v1/results", http.StatusMethodNotAllowed, "GET, HEAD, DELETE, OPTIONS"},
+		{http.MethodPut, "/api/v1/results", http.StatusMethodNotAllowed, "GET, HEAD, DELETE, OPTIONS"},
+		{http.MethodDelete, "/api/v1/images", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{http.MethodPost, "/api/v1/images", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{http.MethodOptions, "/api/v1/images", http.StatusNoContent, "GET, HEAD, OPTIONS"},
+		{http.MethodOptions, "/api/v1/files", http.StatusNoContent, "GET, HEAD, DELETE, OPTIONS"},
+		{http.MethodPut, "/api/v1/files/a.txt", http.StatusMethodNotAllowed, "GET, HEAD, POST, DELETE, OPTIONS"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: status %d, want %d (Location %q)", tt.method, tt.path, rec.Code, tt.code, rec.Header().Get("Location"))
+		}
+		if allow := rec.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+	}
+}
+
+// 只有带斜杠的路由时仍然重定向，没有任何路由时返回 JSON 404
+func TestRedirectAndNotFound(t *testing.T) {
+	rt := api.NewRouter()
+	rt.HandleFunc("GET /static/", func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static", nil))
+	if rec.Code < 300 || rec.Code >= 400 || rec.Header().Get("Location") != "/static/" {
+		t.Errorf("GET /static: status %d, Location %q, want a redirect to /static/", rec.Code, rec.Header().Get("Location"))
+	}
+	if _, pattern := rt.Handler(httptest.NewRequest(http.MethodGet, "/static", nil)); pattern != "" {
+		t.Errorf("Handler(/static) pattern = %q, want none for a redirect", pattern)
+	}
+
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rec.Code != http.StatusNotFound || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("GET /missing: status %d, Content-Type %q, want a JSON 404", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
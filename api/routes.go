@@ -0,0 +1,71 @@
+package api
+
+import (
+	"UPC-GO/metrics"
+	"net/http"
+)
+
+// Routes 注册所有路由，rt.API 注册的接口同时在 /api/v1 和旧的 /api 下
+// 路径存在但方法不对时返回 405，OPTIONS 预检由 Router 统一处理
+func Routes(rt *Router) {
+	rt.HandleFunc("GET /api", ConnectHandler)
+	rt.HandleFunc("GET "+APIPrefix, ConnectHandler)
+	rt.Handle("GET /metrics", metrics.Handler()) // get /metrics Prometheus 指标
+	rt.API("GET /openapi.json", OpenAPIHandler)  // 接口的 OpenAPI 3 文档
+	rt.API("GET /docs", DocsHandler)             // 查看接口文档的页面
+
+	// 终端
+	rt.HandleFunc("GET /ws", HandleWebSocket)
+	rt.HandleFunc("GET "+APIPrefix+"/ws", HandleWebSocket)
+	rt.API("GET /terminals", TerminalsHandler)     // 获取所有终端会话的列表
+	rt.API("GET /terminals/{id}", ViewTerminal)    // 获取一个终端会话的信息
+	rt.API("DELETE /terminals/{id}", KillTerminal) // 结束一个终端会话
+
+	// 文件和结果
+	rt.API("GET /files", FilesHandler)                       // 获取所有文件的列表
+	rt.API("DELETE /files", MultiDeleter)                    // 批量删除文件
+	rt.API("GET /files/{name...}", FileViewer)               // 下载一个文件，文件夹返回文件列表
+	rt.API("POST /files/{name...}", FileProcessor)           // ?mkdir 创建文件夹，否则构建zip文件
+	rt.API("DELETE /files/{name...}", SingleDeleter)         // 删除一个文件
+	rt.API("POST /files/download", MultiDownloader)          // 下载多个文件
+	rt.API("GET /results", ResultsHandler)                   // 获取所有结果的列表
+	rt.API("DELETE /results", MultiResultDeleter)            // 批量删除结果
+	rt.API("GET /results/{name...}", ResultViewer)           // 下载一个结果，文件夹返回文件列表
+	rt.API("POST /results/{name...}", ResultProcessor)       // ?mkdir 创建文件夹
+	rt.API("DELETE /results/{name...}", SingleResultDeleter) // 删除一个结果
+	rt.API("POST /results/download", MultiResultDownloader)  // 下载多个结果
+	rt.API("POST /upload", UploadHandler)                    // 上传文件
+	rt.API("OPTIONS /tus", TusOptions)                       // 断点续传支持的协议版本和扩展
+	rt.API("POST /tus", TusHandler)                          // 创建一个断点续传上传
+	rt.API("OPTIONS /tus/{id}", TusOptions)                  // 断点续传支持的协议版本和扩展
+	rt.API("HEAD /tus/{id}", TusHead)                        // 获取已经上传的字节数
+	rt.API("PATCH /tus/{id}", TusPatch)                      // 继续一个断点续传上传
+	rt.API("DELETE /tus/{id}", TusDelete)                    // 取消一个断点续传上传
+
+	// docker images 和 containers
+	rt.API("GET /images", ImagesHandler)                                // 获取所有docker images 的列表
+	rt.API("GET /images/{name...}", ViewImage)                          // 获取一个docker image 的详细信息
+	rt.API("DELETE /images/{name...}", ImageDeleter)                    // 删除一个docker image
+	rt.API("POST /pull/{name...}", ImagePuller)                         // 拉取一个docker image
+	rt.API("GET /containers", ListContainers)                           // 获取所有docker containers 的列表
+	rt.API("POST /containers", CreateContainer)                         // 创建一个container
+	rt.API("GET /containers/{id}", ViewContainer)                       // 获取一个container 的详细信息
+	rt.API("DELETE /containers/{id}", ContainerDeleter)                 // 删除一个container
+	rt.API("POST /containers/{id}/start", ContainerAction("start"))     // 启动一个container
+	rt.API("POST /containers/{id}/stop", ContainerAction("stop"))       // 停止一个container
+	rt.API("POST /containers/{id}/restart", ContainerAction("restart")) // 重启一个container
+
+	// 构建和任务
+	rt.API("GET /builds", BuildsHandler)             // 获取所有构建任务的列表
+	rt.API("GET /builds/{id}", ViewBuild)            // 获取构建任务的状态
+	rt.API("GET /builds/{id}/logs", BuildLogs)       // 实时获取构建日志
+	rt.API("POST /builds/{id}/cancel", CancelBuild)  // 取消构建
+	rt.API("POST /jobs", RunJob)                     // 用一个docker image 处理上传的文件，结果保存到results
+	rt.API("GET /registration", RegistrationHandler) // 获取本节点在注册中心的注册状态
+}
+
+// get /api 是测试这个服务器是否正常工作
+func ConnectHandler(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	w.Write([]byte("Connect success!"))
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+)
+
+// get /api/terminals 是获取所有终端会话的列表
+// delete /api/terminals/:id 是结束一个终端会话
+// /ws?session=:id 是重新连接一个终端会话，会先收到最近的输出
+// /ws?keep=1 创建的会话在客户端断开后保留，否则最后一个客户端断开时结束会话
+// 会话只对创建它的用户和 admin 可见，其它用户查看、连接或者结束时返回 404
+
+// 终端会话在没有客户端连接后保留的时间，可以在配置文件的 limits.terminalIdleTimeout 中修改
+var SessionIdleTimeout = 10 * time.Minute
+
+// 同时存在的终端会话数量，可以在配置文件的 limits.maxTerminalSessions 中修改
+var MaxSessions = 20
+
+// 每个会话保存的最近输出的字节数，重新连接时回放
+const scrollbackSize = 128 * 1024
+
+// 每个客户端最多缓冲的消息数量，超过时断开这个客户端，避免拖慢其它客户端
+const clientBufferSize = 256
+
+// SessionInfo 是返回给客户端的终端会话信息
+type SessionInfo struct {
+	Id         string `json:"Id"`
+	Name       string `json:"Name"`
+	Command    string `json:"Command"`
+	Container  string `json:"Container,omitempty"` // 在容器中执行时是容器ID
+	Owner      string `json:"Owner,omitempty"`     // 创建会话的用户
+	Created    string `json:"Created"`
+	LastActive string `json:"LastActive"`
+	Clients    int    `json:"Clients"`
+	Cols       uint16 `json:"Cols"`
+	Rows       uint16 `json:"Rows"`
+}
+
+// 终端的后端，本机的PTY或者容器中的 docker exec
+type terminalBackend interface {
+	io.ReadWriteCloser
+	Resize(cols, rows uint16) error
+}
+
+// 本机shell的PTY
+type ptyBackend struct {
+	cmd *exec.Cmd
+	tty *os.File
+	mu  sync.Mutex // tty.Fd 和 tty.Close 不能同时调用
+}
+
+func startPtyBackend(shell string, size *pty.Winsize) (*ptyBackend, error) {
+	cmd := exec.Command(shell)
+	tty, err := pty.StartWithSize(cmd, size)
+	if err != nil {
+		return nil, err
+	}
+	// 回收退出的shell进程
+	go cmd.Wait()
+	return &ptyBackend{cmd: cmd, tty: tty}, nil
+}
+
+func (p *ptyBackend) Read(b []byte) (int, error)  { return p.tty.Read(b) }
+func (p *ptyBackend) Write(b []byte) (int, error) { return p.tty.Write(b) }
+
+func (p *ptyBackend) Resize(cols, rows uint16) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return pty.Setsize(p.tty, &pty.Winsize{Cols: cols, Rows: rows})
+}
+
+func (p *ptyBackend) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cmd.Process.Kill()
+	return p.tty.Close()
+}
+
+// 一个连接到会话的WebSocket客户端
+type sessionClient struct {
+	conn *websocket.Conn
+	send chan Message
+}
+
+// TerminalSession 是一个在服务器上持续运行的终端，客户端断开后仍然保留
+type TerminalSession struct {
+	mu         sync.Mutex
+	info       SessionInfo
+	lastActive time.Time
+	backend    terminalBackend
+	scrollback []byte
+	clients    map[*sessionClient]bool
+	idleTimer  *time.Timer
+	closed     bool
+	keep       bool // 没有客户端时保留会话，直到空闲超时
+	// 创建会话的请求，会话的日志中带上它的请求ID
+	ctx context.Context
+}
+
+// 所有终端会话
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]*TerminalSession)
+)
+
+// 创建一个终端会话并开始读取输出，会话数量已经达到上限时返回 nil，调用者需要关闭 backend
+func newTerminalSession(ctx context.Context, name, command, containerID string, backend terminalBackend, size *pty.Winsize, keep bool) *TerminalSession {
+	now := time.Now()
+	s := &TerminalSession{
+		ctx: context.WithoutCancel(ctx),
+		info: SessionInfo{
+			Id:        newID(),
+			Name:      name,
+			Command:   command,
+			Container: containerID,
+			Owner:     principalName(ctx),
+			Created:   now.Format(time.RFC3339),
+		},
+		lastActive: now,
+		backend:    backend,
+		clients:    make(map[*sessionClient]bool),
+		keep:       keep,
+	}
+	if size != nil {
+		s.info.Cols, s.info.Rows = size.Cols, size.Rows
+	}
+	if s.info.Name == "" {
+		s.info.Name = s.info.Id
+	}
+
+	sessionsMu.Lock()
+	if len(sessions) >= MaxSessions {
+		sessionsMu.Unlock()
+		return nil
+	}
+	sessions[s.info.Id] = s
+	sessionsMu.Unlock()
+
+	go s.readLoop()
+	attrs := []any{"session", s.info.Id, "command", command}
+	if containerID != "" {
+		attrs = append(attrs, "container", containerID)
+	}
+	slog.InfoContext(s.ctx, "Terminal session started", attrs...)
+	return s
+}
+
+// 会话数量是否已经达到上限，启动 shell 之前先检查，newTerminalSession 会再检查一次
+func sessionsFull() bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return len(sessions) >= MaxSessions
+}
+
+// 找到一个终端会话
+func getSession(id string) *TerminalSession {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return sessions[id]
+}
+
+// 请求的用户名，没有认证时为空
+func principalName(ctx context.Context) string {
+	if p := PrincipalFrom(ctx); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// 请求的用户是否可以使用这个会话，只有创建者和 admin 可以
+func (s *TerminalSession) allowed(ctx context.Context) bool {
+	if p := PrincipalFrom(ctx); p != nil && p.Allows(PermAdmin) {
+		return true
+	}
+	return principalName(ctx) == s.info.Owner
+}
+
+// Info 返回终端会话当前的状态
+func (s *TerminalSession) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := s.info
+	info.LastActive = s.lastActive.Format(time.RFC3339)
+	info.Clients = len(s.clients)
+	return info
+}
+
+// 读取终端输出，保存到回放缓冲区并发送给所有客户端
+func (s *TerminalSession) readLoop() {
+	buf := make([]byte, 1024)
+	for {
+		n, err := s.backend.Read(buf)
+		if n > 0 {
+			s.mu.Lock()
+			s.scrollback = append(s.scrollback, buf[:n]...)
+			if len(s.scrollback) > scrollbackSize {
+				s.scrollback = append([]byte(nil), s.scrollback[len(s.scrollback)-scrollbackSize:]...)
+			}
+			s.broadcast(Message{Type: "output", Data: string(buf[:n])})
+			s.mu.Unlock()
+		}
+		if err != nil {
+			// shell 退出
+			s.Close("exited")
+			return
+		}
+	}
+}
+
+// 把消息发送给所有客户端，调用时需要持有 s.mu
+func (s *TerminalSession) broadcast(msg Message) {
+	for client := range s.clients {
+		select {
+		case client.send <- msg:
+		default:
+			// 客户端太慢，断开它
+			delete(s.clients, client)
+			close(client.send)
+		}
+	}
+}
+
+// 连接一个客户端，先回放最近的输出，ctx 是客户端的请求
+// keep 为 true 时会话在客户端断开后保留，用 ?session= 重新连接的客户端总是保留会话
+func (s *TerminalSession) attach(ctx context.Context, conn *websocket.Conn, keep bool) (*sessionClient, bool) {
+	client := &sessionClient{conn: conn, send: make(chan Message, clientBufferSize)}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, false
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	client.send <- Message{Type: "session", Data: s.info.Id}
+	if len(s.scrollback) > 0 {
+		client.send <- Message{Type: "output", Data: string(s.scrollback)}
+	}
+	s.clients[client] = true
+	s.keep = s.keep || keep
+	s.lastActive = time.Now()
+	s.mu.Unlock()
+
+	// 每个客户端一个写入协程，保证同一个连接上只有一个写入者
+	go func() {
+		for msg := range client.send {
+			if err := conn.WriteJSON(msg); err != nil {
+				slog.WarnContext(ctx, "Failed to send terminal output", "session", s.info.Id, "error", err)
+				break
+			}
+		}
+		// 会话结束或者客户端太慢时关闭连接，让读取循环退出
+		conn.Close()
+	}()
+	return client, true
+}
+
+// 断开一个客户端，没有客户端时结束会话，保留的会话开始计算空闲时间
+func (s *TerminalSession) detach(client *sessionClient) {
+	s.mu.Lock()
+	if s.clients[client] {
+		delete(s.clients, client)
+		close(client.send)
+	}
+	s.lastActive = time.Now()
+	if len(s.clients) > 0 || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if s.keep {
+		s.idleTimer = time.AfterFunc(SessionIdleTimeout, func() { s.Close("idle timeout") })
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.Close("disconnected")
+}
+
+// 把输入写入终端
+func (s *TerminalSession) input(data string) error {
+	s.mu.Lock()
+	s.lastActive = time.Now()
+	s.mu.Unlock()
+	_, err := s.backend.Write([]byte(data))
+	return err
+}
+
+// 修改终端大小
+func (s *TerminalSession) resize(cols, rows uint16) error {
+	s.mu.Lock()
+	s.info.Cols, s.info.Rows = cols, rows
+	s.mu.Unlock()
+	return s.backend.Resize(cols, rows)
+}
+
+// Close 结束终端会话，断开所有客户端
+func (s *TerminalSession) Close(reason string) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	s.broadcast(Message{Type: "exit", Data: reason})
+	for client := range s.clients {
+		delete(s.clients, client)
+		close(client.send)
+	}
+	s.mu.Unlock()
+
+	s.backend.Close()
+
+	sessionsMu.Lock()
+	delete(sessions, s.info.Id)
+	sessionsMu.Unlock()
+	slog.InfoContext(s.ctx, "Terminal session closed", "session", s.info.Id, "reason", reason)
+}
+
+// 获取所有终端会话的列表，按创建时间排序
+func TerminalsHandler(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+
+	// 只返回当前用户可以使用的会话
+	sessionsMu.Lock()
+	all := make([]*TerminalSession, 0, len(sessions))
+	for _, s := range sessions {
+		if s.allowed(r.Context()) {
+			all = append(all, s)
+		}
+	}
+	sessionsMu.Unlock()
+
+	infos := make([]SessionInfo, 0, len(all))
+	for _, s := range all {
+		infos = append(infos, s.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created < infos[j].Created })
+	json.NewEncoder(w).Encode(infos)
+}
+
+// 找到路径中 {id} 对应的终端会话，没有或者属于其它用户时返回 404
+func sessionOrError(w http.ResponseWriter, r *http.Request) *TerminalSession {
+	id := r.PathValue("id")
+	s := getSession(id)
+	if s == nil || !s.allowed(r.Context()) {
+		s = nil
+		WriteError(w, http.StatusNotFound, "Terminal session not found: "+id, nil)
+	}
+	return s
+}
+
+// 获取一个终端会话的信息
+func ViewTerminal(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+	if s := sessionOrError(w, r); s != nil {
+		json.NewEncoder(w).Encode(s.Info())
+	}
+}
+
+// 结束一个终端会话
+func KillTerminal(w http.ResponseWriter, r *http.Request) {
+	// 跨域请求
+	Cors(w)
+	s := sessionOrError(w, r)
+	if s == nil {
+		return
+	}
+	s.Close("killed")
+	slog.InfoContext(r.Context(), "Terminal session killed", "session", s.info.Id)
+	json.NewEncoder(w).Encode("Terminal session killed: " + s.info.Id)
+}
@@ -2,24 +2,42 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
+	"strconv"
+	"strings"
 
 	"github.com/creack/pty"
 	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
+	// 只允许同源或者 ALLOWED_ORIGINS 中的来源
+	CheckOrigin: checkOrigin,
+	// 升级失败时也返回统一的JSON错误
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		WriteError(w, status, "Error upgrading to WebSocket", reason)
 	},
 }
 
+// Message 是 /ws 上收发的消息
+// type 为 input 时 data 是键盘输入，为 resize 时 cols 和 rows 是终端的新大小
 type Message struct {
 	Type string `json:"type"`
 	Data string `json:"data"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+// 从连接的查询参数中读取初始的终端大小，例如 /ws?cols=120&rows=40
+func initialSize(r *http.Request) *pty.Winsize {
+	cols, err1 := strconv.ParseUint(r.URL.Query().Get("cols"), 10, 16)
+	rows, err2 := strconv.ParseUint(r.URL.Query().Get("rows"), 10, 16)
+	if err1 != nil || err2 != nil || cols == 0 || rows == 0 {
+		return nil
+	}
+	return &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}
 }
 
 func getAvailableShell() string {
@@ -30,72 +48,113 @@ func getAvailableShell() string {
 	return shell
 }
 
+// 连接一个终端会话: /ws 创建一个新的会话, /ws?container=:id 在容器中创建会话, /ws?session=:id 重新连接已有的会话
+// 连接后会先收到 {type: "session", data: id}，使用 /ws?keep=1 创建的会话断线后可以用这个id重新连接
+// 没有 keep 的会话在最后一个客户端断开时结束
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Failed to upgrade connection:", err)
+		slog.WarnContext(ctx, "Failed to upgrade connection", "error", err)
 		return
 	}
 	defer conn.Close()
 
-	log.Println("New WebSocket connection")
+	slog.InfoContext(ctx, "New WebSocket connection", "remote", r.RemoteAddr)
 
 	// Send service info to the client
 	serviceInfo := "Service Information" // Replace with actual service info
 	serviceMsg := Message{Type: "message", Data: serviceInfo}
 	if err := conn.WriteJSON(serviceMsg); err != nil {
-		log.Println("Failed to send service info:", err)
+		slog.WarnContext(ctx, "Failed to send service info", "error", err)
 		return
 	}
 
-	shell := getAvailableShell()
+	// 找到要连接的会话，或者创建一个新的会话
+	size := initialSize(r)
+	keep, _ := strconv.ParseBool(r.URL.Query().Get("keep"))
+	var session *TerminalSession
+	if id := r.URL.Query().Get("session"); id != "" {
+		session = getSession(id)
+		if session == nil || !session.allowed(ctx) {
+			conn.WriteJSON(Message{Type: "error", Data: "Terminal session not found: " + id})
+			return
+		}
+		if size != nil {
+			session.resize(size.Cols, size.Rows)
+		}
+		keep = true
+	} else if sessionsFull() {
+		slog.WarnContext(ctx, "Too many terminal sessions", "max", MaxSessions)
+		conn.WriteJSON(Message{Type: "error", Data: "Too many terminal sessions"})
+		return
+	} else if containerID := r.URL.Query().Get("container"); containerID != "" {
+		// 在容器中执行命令
+		cmd := execCommand(r.URL.Query().Get("cmd"))
+		backend, err := startExecBackend(containerID, cmd, size)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to exec in container", "container", containerID, "error", err)
+			conn.WriteJSON(Message{Type: "error", Data: "Failed to exec in container: " + err.Error()})
+			return
+		}
+		session = newTerminalSession(ctx, r.URL.Query().Get("name"), strings.Join(cmd, " "), containerID, backend, size, keep)
+		if session == nil {
+			backend.Close()
+		}
+	} else {
+		shell := getAvailableShell()
+		backend, err := startPtyBackend(shell, size)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to start shell", "shell", shell, "error", err)
+			conn.WriteJSON(Message{Type: "error", Data: "Failed to start shell: " + err.Error()})
+			return
+		}
+		session = newTerminalSession(ctx, r.URL.Query().Get("name"), shell, "", backend, size, keep)
+		if session == nil {
+			backend.Close()
+		}
+	}
+	if session == nil {
+		// 启动期间其它连接创建了会话，数量超过了上限
+		conn.WriteJSON(Message{Type: "error", Data: "Too many terminal sessions"})
+		return
+	}
 
-	cmd := exec.Command(shell)
-	tty, err := pty.Start(cmd)
-	if err != nil {
-		log.Println("Failed to start shell:", err)
+	client, ok := session.attach(ctx, conn, keep)
+	if !ok {
+		conn.WriteJSON(Message{Type: "error", Data: "Terminal session closed"})
 		return
 	}
-	defer func() {
-		cmd.Process.Kill()
-		tty.Close()
-	}()
-
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := tty.Read(buf)
-			if err != nil {
-				return
-			}
-			outputMsg := Message{Type: "output", Data: string(buf[:n])}
-			if err := conn.WriteJSON(outputMsg); err != nil {
-				log.Println("Failed to send output:", err)
-				return
-			}
-		}
-	}()
+	// 断开连接时，保留的会话空闲超时后才会结束，其它会话在没有客户端时结束
+	defer session.detach(client)
 
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Read error:", err)
+			slog.DebugContext(ctx, "WebSocket read error", "session", session.info.Id, "error", err)
 			break
 		}
 
 		var msg Message
 		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Println("Unmarshal error:", err)
+			slog.WarnContext(ctx, "Invalid terminal message", "session", session.info.Id, "error", err)
 			continue
 		}
 
 		if msg.Type == "input" {
-			if _, err := tty.Write([]byte(msg.Data)); err != nil {
-				log.Println("Write to TTY error:", err)
+			if err := session.input(msg.Data); err != nil {
+				slog.WarnContext(ctx, "Write to TTY error", "session", session.info.Id, "error", err)
 				break
 			}
 		}
+
+		// 浏览器窗口大小变化时，同步修改PTY的大小
+		if msg.Type == "resize" && msg.Cols > 0 && msg.Rows > 0 {
+			if err := session.resize(msg.Cols, msg.Rows); err != nil {
+				slog.WarnContext(ctx, "Resize TTY error", "session", session.info.Id, "error", err)
+			}
+		}
 	}
 
-	log.Println("User disconnected")
+	slog.InfoContext(ctx, "WebSocket disconnected", "session", session.info.Id)
 }
@@ -0,0 +1,508 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	fpath "path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tus 断点续传协议 (https://tus.io/protocols/resumable-upload), 支持 creation, termination 和 expiration 扩展
+// options /api/tus 是获取服务器支持的协议版本和扩展
+// post /api/tus 是创建一个上传, Upload-Length 是文件大小, Upload-Metadata 中的 filename 和 dir 决定保存位置
+// head /api/tus/:id 是获取已经上传的字节数, 用于断线后继续上传
+// patch /api/tus/:id 是从 Upload-Offset 开始继续上传
+// delete /api/tus/:id 是取消一个上传
+// 上传完成后文件会被移动到 ./uploads, 和 /api/upload 上传的文件一样出现在 /api/files 中, 上传的状态随后删除
+// 超过 Upload-Expires 没有继续的上传会被删除
+
+// 未完成的上传保存在这个目录，服务器重启后仍然可以继续
+var tuspath = "./tus"
+
+const tusVersion = "1.0.0"
+
+// 未完成的上传在最后一次写入后保留的时间，可以在配置文件的 limits.uploadExpiry 中修改
+var tusExpiry = 24 * time.Hour
+
+// TusUpload 是一个上传的状态，保存在 :id.info 中
+type TusUpload struct {
+	Id        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	RawMeta   string            `json:"rawMetadata"`
+	Completed bool              `json:"completed"`
+	File      string            `json:"file"`    // 完成后在 ./uploads 中的路径
+	Expires   time.Time         `json:"expires"` // 超过这个时间没有继续上传时删除
+}
+
+// 同一个上传同时只能有一个请求在写入
+var (
+	tusLocksMu sync.Mutex
+	tusLocks   = make(map[string]*sync.Mutex)
+)
+
+// 返回一个上传的锁，上传不存在时返回 nil，不会为未知的ID创建锁
+func tusLock(id string) *sync.Mutex {
+	tusLocksMu.Lock()
+	defer tusLocksMu.Unlock()
+	lock, ok := tusLocks[id]
+	if !ok {
+		if !validTusID(id) {
+			return nil
+		}
+		if _, err := os.Stat(tuspath + "/" + id + ".info"); err != nil {
+			return nil
+		}
+		lock = &sync.Mutex{}
+		tusLocks[id] = lock
+	}
+	return lock
+}
+
+// id 只能是 newID 生成的十六进制字符串
+func validTusID(id string) bool {
+	return id != "" && strings.Trim(id, "0123456789abcdef") == ""
+}
+
+// 删除一个上传的数据、状态和锁，调用时需要持有它的锁
+func removeTusUpload(id string) {
+	os.Remove(tuspath + "/" + id)
+	os.Remove(tuspath + "/" + id + ".info")
+
+	tusLocksMu.Lock()
+	delete(tusLocks, id)
+	tusLocksMu.Unlock()
+}
+
+// 读取上传的状态，过期的上传当作不存在
+func loadTusUpload(id string) (*TusUpload, error) {
+	upload, err := readTusUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(upload.Expires) {
+		return nil, os.ErrNotExist
+	}
+	return upload, nil
+}
+
+// 读取上传的状态，不检查是否过期
+func readTusUpload(id string) (*TusUpload, error) {
+	if !validTusID(id) {
+		return nil, os.ErrNotExist
+	}
+	infoPath := tuspath + "/" + id + ".info"
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, err
+	}
+	var upload TusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	// 旧版本保存的状态没有过期时间，从最后一次保存开始计算
+	if upload.Expires.IsZero() {
+		stat, err := os.Stat(infoPath)
+		if err != nil {
+			return nil, err
+		}
+		upload.Expires = stat.ModTime().Add(tusExpiry)
+	}
+	return &upload, nil
+}
+
+// 保存上传的状态
+func (u *TusUpload) save() error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tuspath+"/"+u.Id+".info", data, 0o644)
+}
+
+// 解析 Upload-Metadata 头: "filename ZmlsZS50eHQ=,dir cHJvamVjdC1h"
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, errors.New("malformed Upload-Metadata")
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("malformed Upload-Metadata value for %s: %w", fields[0], err)
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata, nil
+}
+
+// 设置所有 tus 响应都需要的头
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// 未完成的上传返回过期时间
+func tusExpiresHeader(w http.ResponseWriter, upload *TusUpload) {
+	if !upload.Completed {
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+// 返回服务器支持的协议版本和扩展
+func TusOptions(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,creation-with-upload,termination,expiration")
+	if maxUploadSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 创建一个上传
+func TusHandler(w http.ResponseWriter, r *http.Request) {
+	Cors(w)
+	tusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return
+	}
+	TusCreate(w, r)
+}
+
+// 检查一个上传请求，返回路径中的上传ID
+func tusRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	Cors(w)
+	tusHeaders(w)
+	if !checkTusVersion(w, r) {
+		return "", false
+	}
+	return r.PathValue("id"), true
+}
+
+// 返回已经上传的字节数
+func TusHead(w http.ResponseWriter, r *http.Request) {
+	id, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.RawMeta != "" {
+		w.Header().Set("Upload-Metadata", upload.RawMeta)
+	}
+	tusExpiresHeader(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// 检查客户端使用的协议版本
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		WriteError(w, http.StatusPreconditionFailed, "Unsupported Tus-Resumable version: "+r.Header.Get("Tus-Resumable"), nil)
+		return false
+	}
+	return true
+}
+
+// 创建一个上传
+func TusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		WriteError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if maxUploadSize > 0 && length > maxUploadSize {
+		WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the limit of %s", getSize(maxUploadSize)), nil)
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+
+	// 检查保存位置是否合法，文件名只使用最后一段
+	filename := path.Base(strings.ReplaceAll(metadata["filename"], "\\", "/"))
+	if filename == "" || filename == "." || filename == "/" {
+		WriteError(w, http.StatusBadRequest, "Error: filename is required in Upload-Metadata", nil)
+		return
+	}
+	dir := strings.Trim(metadata["dir"], "/")
+	if _, ok := resolveOrError(w, filepath, path.Join(dir, filename)); !ok {
+		return
+	}
+
+	if err := os.MkdirAll(tuspath, os.ModePerm); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating the upload folder", err)
+		return
+	}
+	upload := &TusUpload{
+		Id:       newID(),
+		Length:   length,
+		Metadata: metadata,
+		RawMeta:  r.Header.Get("Upload-Metadata"),
+		File:     path.Join(dir, filename),
+		Expires:  time.Now().Add(tusExpiry),
+	}
+
+	// 创建一个空文件用于写入
+	partFile, err := os.Create(tuspath + "/" + upload.Id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating the upload file", err)
+		return
+	}
+	partFile.Close()
+	if err := upload.save(); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error saving the upload info", err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Upload created", "upload", upload.Id, "file", upload.File, "size", getSize(length))
+	w.Header().Set("Location", APIPrefix+"/tus/"+upload.Id)
+
+	// creation-with-upload: 创建时可以直接带上第一段数据
+	if r.Header.Get("Content-Type") == "application/offset+octet-stream" {
+		lock := tusLock(upload.Id)
+		lock.Lock()
+		defer lock.Unlock()
+		if err := writeTusChunk(r, upload); err != nil {
+			WriteError(w, http.StatusInternalServerError, "Error saving the upload", err)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+
+	// 空文件直接完成
+	if upload.Length == 0 && !upload.Completed {
+		if err := finishTusUpload(r.Context(), upload); err != nil {
+			WriteError(w, http.StatusInternalServerError, "Error saving the upload", err)
+			return
+		}
+	}
+
+	tusExpiresHeader(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// 从 Upload-Offset 开始继续上传
+func TusPatch(w http.ResponseWriter, r *http.Request) {
+	id, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		WriteError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	// 同一个上传同时只能有一个PATCH请求
+	lock := tusLock(id)
+	if lock == nil {
+		WriteError(w, http.StatusNotFound, "Upload not found: "+id, nil)
+		return
+	}
+	if !lock.TryLock() {
+		WriteError(w, http.StatusLocked, "Upload is in use by another request", nil)
+		return
+	}
+	defer lock.Unlock()
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "Upload not found: "+id, nil)
+		return
+	}
+	tusExpiresHeader(w, upload)
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		WriteError(w, http.StatusConflict, "Upload-Offset does not match the current offset", nil)
+		return
+	}
+	if upload.Completed {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// 即使连接中断，已经收到的数据也会保存，客户端可以通过HEAD得到新的offset
+	writeErr := writeTusChunk(r, upload)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	tusExpiresHeader(w, upload)
+	if writeErr != nil {
+		WriteError(w, http.StatusInternalServerError, "Error saving the upload", writeErr)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 把请求体写入上传文件，更新offset，上传完成后移动到 ./uploads
+func writeTusChunk(r *http.Request, upload *TusUpload) error {
+	partFile, err := os.OpenFile(tuspath+"/"+upload.Id, os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// 不允许超过声明的文件大小
+	if _, err := partFile.Seek(upload.Offset, io.SeekStart); err != nil {
+		partFile.Close()
+		return err
+	}
+	written, copyErr := io.Copy(partFile, io.LimitReader(r.Body, upload.Length-upload.Offset))
+	closeErr := partFile.Close()
+
+	upload.Offset += written
+	upload.Expires = time.Now().Add(tusExpiry)
+	if err := upload.save(); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if upload.Offset == upload.Length {
+		return finishTusUpload(r.Context(), upload)
+	}
+	return nil
+}
+
+// 把完成的上传移动到 ./uploads，然后删除上传的状态，调用时需要持有它的锁
+func finishTusUpload(ctx context.Context, upload *TusUpload) error {
+	dstPath, err := resolvePath(filepath, upload.File)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fpath.Dir(dstPath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := moveFile(tuspath+"/"+upload.Id, dstPath); err != nil {
+		return err
+	}
+
+	upload.Completed = true
+	removeTusUpload(upload.Id)
+	slog.InfoContext(ctx, "Uploaded", "upload", upload.Id, "file", upload.File, "size", getSize(upload.Length))
+	return nil
+}
+
+// 移动文件，不在同一个文件系统时复制后删除
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// 取消一个上传，删除已经上传的数据
+func TusDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	lock := tusLock(id)
+	if lock == nil {
+		WriteError(w, http.StatusNotFound, "Upload not found: "+id, nil)
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "Upload not found: "+id, nil)
+		return
+	}
+
+	removeTusUpload(upload.Id)
+	slog.InfoContext(r.Context(), "Upload terminated", "upload", upload.Id, "file", upload.File)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunTusExpiry 定期删除过期的上传，启动时先清理一次，一直运行到服务器退出
+func RunTusExpiry() {
+	expireTusUploads(time.Now())
+	ticker := time.NewTicker(min(tusExpiry/4, time.Hour))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		expireTusUploads(now)
+	}
+}
+
+// 删除过期的上传，以及旧版本留下的已完成上传的状态
+func expireTusUploads(now time.Time) {
+	infos, err := fpath.Glob(tuspath + "/*.info")
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		id := strings.TrimSuffix(fpath.Base(info), ".info")
+		lock := tusLock(id)
+		// 已经被其它请求删除，或者正在写入的上传不会过期
+		if lock == nil || !lock.TryLock() {
+			continue
+		}
+		upload, err := readTusUpload(id)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// 已经被其它请求删除
+			tusLocksMu.Lock()
+			delete(tusLocks, id)
+			tusLocksMu.Unlock()
+		case err != nil:
+			slog.Warn("Removing unreadable upload", "upload", id, "error", err)
+			removeTusUpload(id)
+		case upload.Completed || now.After(upload.Expires):
+			removeTusUpload(id)
+			slog.Info("Upload expired", "upload", id, "file", upload.File, "offset", upload.Offset, "size", upload.Length)
+		}
+		lock.Unlock()
+	}
+}
@@ -2,39 +2,51 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
+	"strings"
 )
 
+// 上传表单在内存中缓冲的大小，超过的部分写入临时文件
+var formMemory int64 = 100 << 20 // 100MB
+
+// 单次上传的最大大小，0 表示不限制
+var maxUploadSize int64
+
 // 上传单个或多个文件
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	Cors(w)
 
-	// 如果是OPTIONS预检请求，返回200
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
+	// 上传的目标路径，?dir=project-a 上传到子文件夹
+	dir := strings.Trim(r.URL.Query().Get("dir"), "/")
+	targetPath, ok := resolveOrError(w, filepath, dir)
+	if !ok {
 		return
 	}
-
-	// 必须是POST请求
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 上传的目标路径
-	targetPath := filepath
 	// 如果文件夹不存在，创建一个
 	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		os.Mkdir(targetPath, os.ModePerm)
+		os.MkdirAll(targetPath, os.ModePerm)
+	}
+
+	// 限制上传的大小
+	if maxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 	}
 
 	// 解析请求
-	err := r.ParseMultipartForm(100 << 20) // 100MB
+	err := r.ParseMultipartForm(formMemory)
 	if err != nil {
-		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the limit of %s", getSize(maxUploadSize)), err)
+			return
+		}
+		WriteError(w, http.StatusBadRequest, "Error parsing form", err)
 		return
 	}
 	// 清理暂存文件
@@ -45,36 +57,42 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 
 	// 对于接收到的每个文件，提取文件名，创造目标文件路径，通过io.Copy()函数将文件内容写入目标文件
 	for _, fileHeader := range files {
-		// 打印文件信息，包括文件名和文件大小，把文件大小转化为人类可读的格式
-		size := fileHeader.Size
-		sizeStr := getSize(size)
-		fmt.Printf("Uploaded: %s --- Size: %s\n", fileHeader.Filename, sizeStr)
+		// 记录文件信息，包括文件名和文件大小，把文件大小转化为人类可读的格式
+		slog.InfoContext(r.Context(), "Uploaded", "file", fileHeader.Filename, "size", getSize(fileHeader.Size))
 
 		// 打开这个文件
 		file, err := fileHeader.Open()
 		if err != nil {
-			http.Error(w, "Error retrieving the file", http.StatusBadRequest)
+			WriteError(w, http.StatusBadRequest, "Error retrieving the file", err)
 			return
 		}
 		defer file.Close()
 
-		// 创建目标文件
-		dstPath := targetPath + "/" + fileHeader.Filename
+		// 创建目标文件，只使用文件名本身，忽略客户端给的路径
+		name := path.Base(strings.ReplaceAll(fileHeader.Filename, "\\", "/"))
+		if name == "/" || name == "." {
+			WriteError(w, http.StatusBadRequest, "Invalid file name: "+fileHeader.Filename, nil)
+			return
+		}
+		dstPath, ok := resolveOrError(w, targetPath, name)
+		if !ok {
+			return
+		}
 		dst, err := os.Create(dstPath)
 		if err != nil {
-			http.Error(w, "Error creating the file", http.StatusInternalServerError)
+			WriteError(w, http.StatusInternalServerError, "Error creating the file", err)
 			return
 		}
 		defer dst.Close()
 
 		// 将文件内容写入目标文件
 		if _, err := io.Copy(dst, file); err != nil {
-			http.Error(w, "Error saving the file", http.StatusInternalServerError)
+			WriteError(w, http.StatusInternalServerError, "Error saving the file", err)
 			return
 		}
 
 		// 记录上传的文件名到uploadedFiles数组
-		uploadedFiles = append(uploadedFiles, fileHeader.Filename)
+		uploadedFiles = append(uploadedFiles, path.Join(dir, name))
 	}
 	//fmt.Println("Uploaded files: ", uploadedFiles)
 	json.NewEncoder(w).Encode(uploadedFiles)
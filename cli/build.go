@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"UPC-GO/client"
+	"flag"
+	"fmt"
+	"os"
+	fpath "path/filepath"
+)
+
+// build 子命令，本地存在同名 zip 文件时先上传
+func buildCommand() *command {
+	var dir string
+	var detach bool
+	return &command{
+		name: "build",
+		args: "app.zip",
+		help: "Build a docker image from a zip file with buildpacks and follow the build logs\n" +
+			"A local zip file is uploaded first, otherwise the name refers to an uploaded file.",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&dir, "dir", "", "upload the local zip file into this folder")
+			fs.BoolVar(&detach, "detach", false, "start the build and exit without following the logs")
+		},
+		run: func(c *cli, args []string) error {
+			if len(args) != 1 {
+				return &usageError{msg: "expected one zip file"}
+			}
+			return build(c, args[0], dir, detach)
+		},
+	}
+}
+
+func build(c *cli, zipName, dir string, detach bool) error {
+	if info, err := os.Stat(zipName); err == nil && !info.IsDir() {
+		progress, done := c.progress(fpath.Base(zipName))
+		name, err := c.client.UploadResumable(c.ctx, dir, zipName, progress)
+		done()
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", zipName, err)
+		}
+		c.infof("Uploaded %s as %s", zipName, name)
+		zipName = name
+	}
+
+	b, err := c.client.Build(c.ctx, zipName)
+	if err != nil {
+		return err
+	}
+	c.infof("Build %s of image %s %s", b.Id, b.Image, b.Status)
+	if detach {
+		if c.json {
+			return c.printJSON(b)
+		}
+		return nil
+	}
+
+	// -json 时日志输出到 stderr，stdout 只有最终状态
+	logs := c.stdout
+	if c.json {
+		logs = c.stderr
+	}
+	final, err := c.client.FollowBuild(c.ctx, b.Id, logs)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			c.infof("Build %s is still running on the server", b.Id)
+		}
+		return err
+	}
+	if c.json {
+		if err := c.printJSON(final); err != nil {
+			return err
+		}
+	}
+	switch final.Status {
+	case client.BuildSucceeded:
+		c.infof("Built image %s", final.Image)
+		return nil
+	case client.BuildFailed:
+		return fmt.Errorf("build %s failed: %s", final.Id, final.Error)
+	default:
+		return fmt.Errorf("build %s %s", final.Id, final.Status)
+	}
+}
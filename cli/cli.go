@@ -0,0 +1,342 @@
+package cli
+
+import (
+	"UPC-GO/client"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/moby/term"
+)
+
+// 连接远程 UPC 节点的子命令，例如:
+//
+//	upc files ls project-a
+//	upc files put -dir project-a input.csv
+//	upc build app.zip
+//	upc shell
+//
+// 服务器地址和 token 默认来自 UPC_SERVER 和 UPC_TOKEN 环境变量，
+// 默认输出表格，-json 输出 JSON
+
+// 默认的服务器地址，和服务器的默认端口一致
+const defaultServer = "http://localhost:4000"
+
+// 一个子命令
+type command struct {
+	name  string // 例如 "files ls"
+	args  string // 参数说明
+	help  string
+	run   func(c *cli, args []string) error
+	flags func(fs *flag.FlagSet) // 子命令自己的参数
+}
+
+// 子命令的用法，例如 upc files ls [flags] [dir]
+func (cmd *command) usage() string {
+	return strings.TrimSpace("upc " + cmd.name + " [flags] " + cmd.args)
+}
+
+// 所有子命令，help 按这个顺序显示
+var commands []*command
+
+func init() {
+	commands = append(commands, storageCommands("files")...)
+	commands = append(commands, storageCommands("results")...)
+	commands = append(commands, imageCommands()...)
+	commands = append(commands, buildCommand(), shellCommand())
+}
+
+// IsCommand 判断 name 是不是子命令，不是子命令时启动服务器
+func IsCommand(name string) bool {
+	if name == "help" {
+		return true
+	}
+	for _, cmd := range commands {
+		if strings.Fields(cmd.name)[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 命令行参数错误，退出码是 2
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+// 所有子命令共用的参数和输出
+type cli struct {
+	server   string
+	token    string
+	node     string
+	insecure bool
+	json     bool
+
+	stdout io.Writer
+	stderr io.Writer
+	client *client.Client
+	ctx    context.Context
+}
+
+// Run 运行一个子命令，args 不包含程序名，返回退出码
+func Run(args []string) int {
+	c := &cli{stdout: os.Stdout, stderr: os.Stderr}
+	// Ctrl-C 取消正在进行的请求
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c.ctx = ctx
+
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		if len(args) > 0 && args[0] != "help" {
+			fmt.Fprintf(c.stderr, "upc: unknown command %q\n\n", strings.Join(args, " "))
+			c.usage()
+			return 2
+		}
+		c.usage()
+		return 0
+	}
+
+	err := c.runCommand(cmd, rest)
+	var usageErr *usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usageErr) && usageErr.msg == "":
+		return 2
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(c.stderr, "upc %s: %s\nusage: %s\n", cmd.name, usageErr.msg, cmd.usage())
+		return 2
+	case ctx.Err() != nil:
+		fmt.Fprintln(c.stderr, "upc: interrupted")
+		return 130
+	default:
+		fmt.Fprintf(c.stderr, "upc %s: %v\n", cmd.name, err)
+		return 1
+	}
+}
+
+// 找到子命令，先匹配两个词的子命令，例如 "files ls"，再匹配一个词的子命令
+func findCommand(args []string) (*command, []string) {
+	if len(args) >= 2 {
+		for _, cmd := range commands {
+			if cmd.name == args[0]+" "+args[1] {
+				return cmd, args[2:]
+			}
+		}
+	}
+	if len(args) >= 1 {
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				return cmd, args[1:]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// 显示所有子命令
+func (c *cli) usage() {
+	fmt.Fprintf(c.stderr, "Usage:\n  upc [flags]                 start the server\n  upc <command> [flags] ...   talk to a remote UPC node\n\nCommands:\n")
+	w := tabwriter.NewWriter(c.stderr, 0, 0, 3, ' ', 0)
+	for _, cmd := range commands {
+		// 只显示说明的第一行
+		help, _, _ := strings.Cut(cmd.help, "\n")
+		fmt.Fprintf(w, "  %s\t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), help)
+	}
+	w.Flush()
+	fmt.Fprintf(c.stderr, "\nCommon flags:\n")
+	fs := flag.NewFlagSet("upc", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	c.commonFlags(fs)
+	fs.PrintDefaults()
+	fmt.Fprintf(c.stderr, "\nRun 'upc <command> -h' for the flags of a command.\n")
+}
+
+// 所有子命令共用的参数
+func (c *cli) commonFlags(fs *flag.FlagSet) {
+	server := os.Getenv("UPC_SERVER")
+	if server == "" {
+		server = defaultServer
+	}
+	fs.StringVar(&c.server, "server", server, "server URL ($UPC_SERVER)")
+	fs.StringVar(&c.token, "token", os.Getenv("UPC_TOKEN"), "API key or JWT ($UPC_TOKEN)")
+	fs.StringVar(&c.node, "node", "", "run the command on this node through the registry at -server")
+	fs.BoolVar(&c.insecure, "insecure", false, "skip TLS certificate verification")
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of tables")
+}
+
+// 解析参数，创建客户端，然后运行子命令
+func (c *cli) runCommand(cmd *command, args []string) error {
+	fs := flag.NewFlagSet("upc "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: %s\n\n%s\n\nFlags:\n", cmd.usage(), cmd.help)
+		fs.PrintDefaults()
+	}
+	c.commonFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		// flag 已经输出了错误和用法
+		return &usageError{}
+	}
+
+	if c.client, err = c.newClient(); err != nil {
+		return err
+	}
+	return cmd.run(c, positional)
+}
+
+// 参数和标志可以混在一起，例如 upc files ls project-a -json，-- 之后都是参数
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// 根据参数创建客户端
+func (c *cli) newClient() (*client.Client, error) {
+	cl, err := client.New(c.server)
+	if err != nil {
+		return nil, err
+	}
+	cl.Token = c.token
+	if c.insecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		cl.HTTPClient = &http.Client{Transport: transport}
+	}
+	if c.node != "" {
+		cl = cl.OnNode(c.node)
+	}
+	return cl, nil
+}
+
+// ************************************************  输出  ************************************************
+
+// 输出 JSON
+func (c *cli) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// 输出表格，第一行是表头
+func (c *cli) printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// 输出两列的键值表格，按键排序
+func (c *cli) printFields(fields map[string]string) error {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 3, ' ', 0)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s:\t%s\n", key, fields[key])
+	}
+	return w.Flush()
+}
+
+// 输出提示信息，-json 时也输出到 stderr，不影响 stdout 中的 JSON
+func (c *cli) infof(format string, args ...interface{}) {
+	fmt.Fprintf(c.stderr, format+"\n", args...)
+}
+
+// 和服务器的文件列表相同的大小格式
+func formatSize(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.2f KB", float64(size)/1024)
+	case size < 1024*1024*1024:
+		return fmt.Sprintf("%.2f MB", float64(size)/1024/1024)
+	default:
+		return fmt.Sprintf("%.2f GB", float64(size)/1024/1024/1024)
+	}
+}
+
+// ************************************************  进度  ************************************************
+
+// 在终端中显示一行传输进度，stderr 不是终端或者 -json 时不显示
+type progressLine struct {
+	w     io.Writer
+	name  string
+	last  time.Time
+	shown bool
+}
+
+// 返回 name 的进度回调和传输结束后调用的 done
+func (c *cli) progress(name string) (client.ProgressFunc, func()) {
+	if c.json || !isTerminal(c.stderr) {
+		return nil, func() {}
+	}
+	p := &progressLine{w: c.stderr, name: name}
+	return p.update, p.done
+}
+
+func (p *progressLine) update(done, total int64) {
+	// 最多每秒刷新10次，最后一次总是显示
+	if time.Since(p.last) < 100*time.Millisecond && done != total {
+		return
+	}
+	p.last = time.Now()
+	p.shown = true
+	if total > 0 {
+		fmt.Fprintf(p.w, "\r\x1b[K%s  %s / %s  %3d%%", p.name, formatSize(done), formatSize(total), done*100/total)
+	} else {
+		fmt.Fprintf(p.w, "\r\x1b[K%s  %s", p.name, formatSize(done))
+	}
+}
+
+func (p *progressLine) done() {
+	if p.shown {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// 判断 w 是不是终端
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(f.Fd())
+}
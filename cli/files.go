@@ -0,0 +1,300 @@
+package cli
+
+import (
+	"UPC-GO/client"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	fpath "path/filepath"
+	"strings"
+)
+
+// files 和 results 的子命令，results 只能查看和下载
+func storageCommands(kind string) []*command {
+	storage := func(c *cli) *client.Storage {
+		if kind == "results" {
+			return c.client.Results
+		}
+		return c.client.Files
+	}
+	var what string
+	if kind == "results" {
+		what = "job results"
+	} else {
+		what = "uploaded files"
+	}
+
+	var opts client.ListOptions
+	var ext string
+	cmds := []*command{{
+		name: kind + " ls",
+		args: "[dir]",
+		help: "List " + what,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&opts.Sort, "sort", "name", "sort by name, size, time or type")
+			fs.BoolVar(&opts.Desc, "desc", false, "sort in descending order")
+			fs.StringVar(&opts.Glob, "glob", "", "only list names matching this pattern, e.g. '*.csv'")
+			fs.StringVar(&ext, "ext", "", "only list these comma separated extensions")
+			fs.BoolVar(&opts.Digest, "sha256", false, "also show the SHA-256 of each file")
+		},
+		run: func(c *cli, args []string) error {
+			if len(args) > 1 {
+				return &usageError{msg: "too many arguments"}
+			}
+			if ext != "" {
+				opts.Ext = strings.Split(ext, ",")
+			}
+			var dir string
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			return listFiles(c, storage(c), dir, &opts)
+		},
+	}}
+
+	var output, archive string
+	cmds = append(cmds, &command{
+		name: kind + " get",
+		args: "name...",
+		help: "Download " + what + "\nWith -archive, download several files or folders as one zip or tar.gz archive.",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&output, "o", "", "output file, or folder when downloading several files; - writes to stdout")
+			fs.StringVar(&archive, "archive", "", "download as one "+client.ArchiveZip+" or "+client.ArchiveTarGz+" archive")
+		},
+		run: func(c *cli, args []string) error {
+			if len(args) == 0 && archive == "" {
+				return &usageError{msg: "missing file name"}
+			}
+			if archive != "" {
+				return downloadArchive(c, storage(c), kind, args, archive, output)
+			}
+			return downloadFiles(c, storage(c), args, output)
+		},
+	})
+
+	if kind == "results" {
+		return cmds
+	}
+
+	var dir string
+	var resumable bool
+	cmds = append(cmds, &command{
+		name: kind + " put",
+		args: "file...",
+		help: "Upload local files",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&dir, "dir", "", "upload into this folder, created if missing")
+			fs.BoolVar(&resumable, "resumable", false, "upload with tus, resuming after network errors")
+		},
+		run: func(c *cli, args []string) error {
+			if len(args) == 0 {
+				return &usageError{msg: "missing file name"}
+			}
+			return uploadFiles(c, dir, args, resumable)
+		},
+	}, &command{
+		name: kind + " rm",
+		args: "name...",
+		help: "Delete " + what + " or folders",
+		run: func(c *cli, args []string) error {
+			if len(args) == 0 {
+				return &usageError{msg: "missing file name"}
+			}
+			return deleteFiles(c, storage(c), args)
+		},
+	})
+	return cmds
+}
+
+// 列出文件，文件夹的名称以 / 结尾
+func listFiles(c *cli, s *client.Storage, dir string, opts *client.ListOptions) error {
+	list, err := s.List(c.ctx, dir, opts)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(list.Entries)
+	}
+
+	header := []string{"NAME", "SIZE", "MODIFIED"}
+	if opts.Digest {
+		header = append(header, "SHA256")
+	}
+	rows := make([][]string, 0, len(list.Entries))
+	for _, entry := range list.Entries {
+		name, size := entry.Name, entry.SizeHuman
+		if entry.IsDir {
+			name, size = name+"/", "-"
+		}
+		row := []string{name, size, entry.ModTime}
+		if opts.Digest {
+			row = append(row, entry.Sha256)
+		}
+		rows = append(rows, row)
+	}
+	return c.printTable(header, rows)
+}
+
+// 一个下载或上传的结果，用于 -json 输出
+type transfer struct {
+	Name string `json:"name"` // 服务器上的名称
+	Path string `json:"path"` // 本地路径
+	Size int64  `json:"size"`
+}
+
+// 下载文件，一个文件时 output 是文件名，多个文件时 output 是文件夹
+func downloadFiles(c *cli, s *client.Storage, names []string, output string) error {
+	if output == "-" {
+		if len(names) > 1 {
+			return &usageError{msg: "-o - can only be used with one file"}
+		}
+		_, err := s.Download(c.ctx, names[0], c.stdout, nil)
+		return err
+	}
+
+	var results []transfer
+	for _, name := range names {
+		local := path.Base(name)
+		switch {
+		case len(names) == 1 && output != "":
+			local = output
+		case output != "":
+			local = fpath.Join(output, local)
+		}
+		if info, err := os.Stat(local); err == nil && info.IsDir() {
+			local = fpath.Join(local, path.Base(name))
+		}
+
+		progress, done := c.progress(name)
+		err := s.DownloadFile(c.ctx, name, local, progress)
+		done()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		info, err := os.Stat(local)
+		if err != nil {
+			return err
+		}
+		results = append(results, transfer{Name: name, Path: local, Size: info.Size()})
+		if !c.json {
+			c.infof("Downloaded %s to %s (%s)", name, local, formatSize(info.Size()))
+		}
+	}
+	if c.json {
+		return c.printJSON(results)
+	}
+	return nil
+}
+
+// 把多个文件或文件夹打包下载，names 为空时打包全部文件
+func downloadArchive(c *cli, s *client.Storage, kind string, names []string, format, output string) error {
+	if format != client.ArchiveZip && format != client.ArchiveTarGz {
+		return &usageError{msg: fmt.Sprintf("unsupported archive format %q", format)}
+	}
+	if output == "" {
+		output = kind + "." + format
+	}
+	w := c.stdout
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	progress, done := c.progress(output)
+	n, err := s.DownloadArchive(c.ctx, names, format, w, progress)
+	done()
+	if err != nil {
+		if output != "-" {
+			os.Remove(output)
+		}
+		return err
+	}
+	if output == "-" {
+		return nil
+	}
+	if c.json {
+		return c.printJSON(transfer{Name: strings.Join(names, ","), Path: output, Size: n})
+	}
+	c.infof("Downloaded %s (%s)", output, formatSize(n))
+	return nil
+}
+
+// 上传本地文件
+func uploadFiles(c *cli, dir string, localPaths []string, resumable bool) error {
+	var results []transfer
+	for _, local := range localPaths {
+		info, err := os.Stat(local)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a folder, upload the files in it or a zip archive", local)
+		}
+	}
+
+	if resumable {
+		// tus 一次上传一个文件
+		for _, local := range localPaths {
+			progress, done := c.progress(fpath.Base(local))
+			name, err := c.client.UploadResumable(c.ctx, dir, local, progress)
+			done()
+			if err != nil {
+				return fmt.Errorf("%s: %w", local, err)
+			}
+			results = append(results, transfer{Name: name, Path: local})
+		}
+	} else {
+		label := fpath.Base(localPaths[0])
+		if len(localPaths) > 1 {
+			label = fmt.Sprintf("%d files", len(localPaths))
+		}
+		progress, done := c.progress(label)
+		names, err := c.client.Upload(c.ctx, dir, localPaths, progress)
+		done()
+		if err != nil {
+			return err
+		}
+		// 服务器按上传的顺序返回文件名
+		for i, name := range names {
+			results = append(results, transfer{Name: name, Path: localPaths[min(i, len(localPaths)-1)]})
+		}
+	}
+
+	for i := range results {
+		if info, err := os.Stat(results[i].Path); err == nil {
+			results[i].Size = info.Size()
+		}
+	}
+	if c.json {
+		return c.printJSON(results)
+	}
+	for _, r := range results {
+		c.infof("Uploaded %s as %s (%s)", r.Path, r.Name, formatSize(r.Size))
+	}
+	return nil
+}
+
+// 删除文件或文件夹
+func deleteFiles(c *cli, s *client.Storage, names []string) error {
+	var err error
+	if len(names) == 1 {
+		err = s.Delete(c.ctx, names[0])
+	} else {
+		err = s.DeleteMany(c.ctx, names)
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string][]string{"deleted": names})
+	}
+	for _, name := range names {
+		c.infof("Deleted %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"UPC-GO/client"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// images 的子命令
+func imageCommands() []*command {
+	return []*command{{
+		name: "images ls",
+		help: "List docker images",
+		run: func(c *cli, args []string) error {
+			if len(args) > 0 {
+				return &usageError{msg: "too many arguments"}
+			}
+			images, err := c.client.ListImages(c.ctx)
+			if err != nil {
+				return err
+			}
+			if c.json {
+				return c.printJSON(images)
+			}
+			rows := make([][]string, 0, len(images))
+			for _, image := range images {
+				rows = append(rows, []string{image})
+			}
+			return c.printTable([]string{"IMAGE"}, rows)
+		},
+	}, {
+		name: "images inspect",
+		args: "image...",
+		help: "Show the details of docker images",
+		run: func(c *cli, args []string) error {
+			if len(args) == 0 {
+				return &usageError{msg: "missing image name"}
+			}
+			return inspectImages(c, args)
+		},
+	}, {
+		name: "images pull",
+		args: "image",
+		help: "Pull a docker image, showing the progress of each layer",
+		run: func(c *cli, args []string) error {
+			if len(args) != 1 {
+				return &usageError{msg: "expected one image name"}
+			}
+			return pullImage(c, args[0])
+		},
+	}, {
+		name: "images rm",
+		args: "image...",
+		help: "Delete docker images",
+		run: func(c *cli, args []string) error {
+			if len(args) == 0 {
+				return &usageError{msg: "missing image name"}
+			}
+			for _, image := range args {
+				if err := c.client.DeleteImage(c.ctx, image); err != nil {
+					return fmt.Errorf("%s: %w", image, err)
+				}
+				if !c.json {
+					c.infof("Deleted %s", image)
+				}
+			}
+			if c.json {
+				return c.printJSON(map[string][]string{"deleted": args})
+			}
+			return nil
+		},
+	}}
+}
+
+// 显示镜像的详细信息，多个镜像之间空一行
+func inspectImages(c *cli, images []string) error {
+	var all []*client.ImageDetails
+	for _, image := range images {
+		details, err := c.client.InspectImage(c.ctx, image)
+		if err != nil {
+			return fmt.Errorf("%s: %w", image, err)
+		}
+		all = append(all, details)
+	}
+	if c.json {
+		if len(all) == 1 {
+			return c.printJSON(all[0])
+		}
+		return c.printJSON(all)
+	}
+	for i, d := range all {
+		if i > 0 {
+			fmt.Fprintln(c.stdout)
+		}
+		err := c.printFields(map[string]string{
+			"Id":            d.Id,
+			"Tags":          strings.Join(d.RepositoryTags, ", "),
+			"Created":       d.Created,
+			"Size":          d.Size,
+			"Platform":      d.Os + "/" + d.Architecture,
+			"WorkingDir":    d.WorkingDir,
+			"Entrypoint":    strings.Join(d.Entrypoint, " "),
+			"Cmd":           strings.Join(d.Cmd, " "),
+			"DockerVersion": d.DockerVersion,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 拉取镜像，-json 时每行输出一个进度，否则每一层的状态变化时输出一行
+func pullImage(c *cli, image string) error {
+	layers := map[string]string{}
+	lines := json.NewEncoder(c.stdout)
+	err := c.client.PullImage(c.ctx, image, func(p client.PullProgress) {
+		if c.json {
+			lines.Encode(p)
+			return
+		}
+		if p.Error != "" || p.Status == "" || layers[p.Id] == p.Status {
+			return
+		}
+		layers[p.Id] = p.Status
+		if p.Id != "" {
+			fmt.Fprintf(c.stdout, "%s: %s\n", p.Id, p.Status)
+		} else {
+			fmt.Fprintln(c.stdout, p.Status)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !c.json {
+		c.infof("Pulled %s", image)
+	}
+	return nil
+}
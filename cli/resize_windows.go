@@ -0,0 +1,22 @@
+//go:build windows
+
+package cli
+
+import (
+	"context"
+	"time"
+)
+
+// Windows 没有 SIGWINCH，定时调用 resize 检查终端大小
+func watchResize(ctx context.Context, resize func()) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			resize()
+		}
+	}
+}
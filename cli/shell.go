@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"UPC-GO/client"
+	"context"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/moby/term"
+)
+
+// shell 子命令，在本地终端中打开服务器上的终端
+func shellCommand() *command {
+	var opts client.TerminalOptions
+	return &command{
+		name: "shell",
+		help: "Open a terminal on the server in the local terminal\n" +
+			"Closing the connection keeps the session running on the server, reattach to it with -session.\n" +
+			"Use -keep=false to end the session when the connection closes.",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&opts.Session, "session", "", "attach to this existing session")
+			fs.BoolVar(&opts.Keep, "keep", true, "keep the new session running on the server after disconnecting")
+			fs.StringVar(&opts.Container, "container", "", "open the terminal in this container")
+			fs.StringVar(&opts.Cmd, "cmd", "", "command to run in the container (default /bin/sh)")
+			fs.StringVar(&opts.Name, "name", "", "name of the new session")
+		},
+		run: func(c *cli, args []string) error {
+			if len(args) > 0 {
+				return &usageError{msg: "too many arguments"}
+			}
+			return shell(c, &opts)
+		},
+	}
+}
+
+func shell(c *cli, opts *client.TerminalOptions) error {
+	inFd, inTerminal := term.GetFdInfo(os.Stdin)
+	if inTerminal {
+		if size, err := term.GetWinsize(inFd); err == nil {
+			opts.Cols, opts.Rows = size.Width, size.Height
+		}
+	}
+
+	t, err := c.client.OpenTerminal(c.ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	c.infof("Connected to session %s", t.ID)
+
+	// 本地终端进入 raw 模式，按键原样发送到服务器，包括 Ctrl-C
+	var state *term.State
+	if inTerminal {
+		if state, err = term.SetRawTerminal(inFd); err != nil {
+			return err
+		}
+		defer term.RestoreTerminal(inFd, state)
+
+		ctx, cancel := context.WithCancel(c.ctx)
+		defer cancel()
+		go watchResize(ctx, func() {
+			size, err := term.GetWinsize(inFd)
+			if err == nil && (size.Width != opts.Cols || size.Height != opts.Rows) {
+				opts.Cols, opts.Rows = size.Width, size.Height
+				t.Resize(size.Width, size.Height)
+			}
+		})
+	}
+
+	go func() {
+		io.Copy(t, os.Stdin)
+		// 输入不是终端时，输入结束后发送 Ctrl-D 让 shell 退出
+		if !inTerminal {
+			t.Write([]byte{4})
+		}
+	}()
+
+	_, err = io.Copy(c.stdout, t)
+	if state != nil {
+		// 先恢复终端再输出提示，raw 模式下换行不会回到行首
+		term.RestoreTerminal(inFd, state)
+	}
+	if err != nil {
+		return err
+	}
+	reason := t.ExitReason()
+	if reason == "" {
+		reason = "exited"
+	}
+	c.infof("Session %s %s", t.ID, reason)
+	return nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Build 把 uploads 中的一个 zip 文件加入构建队列，使用 buildpack 创建一个 docker image
+func (c *Client) Build(ctx context.Context, zipName string) (*BuildInfo, error) {
+	var build BuildInfo
+	// 重试会创建重复的构建，不重试
+	if err := c.doJSON(ctx, newRequest(http.MethodPost, apiPath("files", zipName)), &build); err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// ListBuilds 返回所有构建任务，按创建时间倒序
+func (c *Client) ListBuilds(ctx context.Context) ([]BuildInfo, error) {
+	var builds []BuildInfo
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("builds")), &builds); err != nil {
+		return nil, err
+	}
+	return builds, nil
+}
+
+// GetBuild 返回一个构建任务的状态
+func (c *Client) GetBuild(ctx context.Context, id string) (*BuildInfo, error) {
+	var build BuildInfo
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("builds", id)), &build); err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// CancelBuild 取消一个构建任务，已经结束的构建返回 409
+func (c *Client) CancelBuild(ctx context.Context, id string) (*BuildInfo, error) {
+	var build BuildInfo
+	req := newRequest(http.MethodPost, apiPath("builds", id, "cancel"))
+	req.retry = true
+	if err := c.doJSON(ctx, req, &build); err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// BuildLogs 把构建日志写入 w，follow 为 true 时一直等到构建结束
+func (c *Client) BuildLogs(ctx context.Context, id string, follow bool, w io.Writer) error {
+	req := newRequest(http.MethodGet, apiPath("builds", id, "logs"))
+	req.query.Set("follow", strconv.FormatBool(follow))
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// FollowBuild 把构建日志实时写入 w，直到构建结束，返回构建的最终状态
+// 连接中断时重新连接，跳过已经写入的日志
+func (c *Client) FollowBuild(ctx context.Context, id string, w io.Writer) (*BuildInfo, error) {
+	var written int64
+	for attempt := 0; ; {
+		req := newRequest(http.MethodGet, apiPath("builds", id, "logs"))
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		// 日志总是从头开始返回
+		skipped, err := io.CopyN(io.Discard, resp.Body, written)
+		if err == nil {
+			var n int64
+			n, err = io.Copy(w, resp.Body)
+			written += n
+		} else if err == io.EOF && skipped == written {
+			err = nil
+		}
+		resp.Body.Close()
+
+		if err == nil {
+			// 日志结束，确认构建已经结束，服务器重启等情况下日志可能提前结束
+			build, err := c.GetBuild(ctx, id)
+			if err != nil || build.Done() {
+				return build, err
+			}
+			err = fmt.Errorf("client: build %s logs ended while the build is %s", id, build.Status)
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if !transientError(err) {
+			return nil, err
+		}
+
+		if attempt >= c.MaxRetries {
+			return nil, err
+		}
+		if err := c.wait(ctx, attempt, 0); err != nil {
+			return nil, err
+		}
+		attempt++
+	}
+}
@@ -0,0 +1,367 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UPC 服务器接口的 Go 客户端，例如:
+//
+//	c, err := client.New("http://localhost:4555")
+//	c.Token = "my-api-key"
+//	names, err := c.Upload(ctx, "project-a", []string{"input.csv"}, nil)
+//	build, err := c.Build(ctx, "app.zip")
+//	build, err = c.FollowBuild(ctx, build.Id, os.Stdout)
+//
+// 所有方法都支持 context，网络错误和 429、502、503、504 会按指数退避自动重试，
+// 上传、下载和拉取镜像可以通过回调获取进度
+
+// 接口的前缀，和服务器的 api.APIPrefix 一致
+const apiPrefix = "/api/v1"
+
+// 默认的重试次数和第一次重试前等待的时间
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryWait  = 500 * time.Millisecond
+)
+
+// 重试等待的上限
+const maxRetryWait = 10 * time.Second
+
+// ProgressFunc 报告传输进度，total 未知时为 -1，重试时 done 会从头开始
+type ProgressFunc func(done, total int64)
+
+// Client 是 UPC 服务器的客户端，创建后可以修改导出的字段，不要在使用中修改
+type Client struct {
+	// HTTPClient 发送请求，默认是没有超时的 http.Client，构建日志和拉取镜像的响应可能持续很久
+	HTTPClient *http.Client
+	// Token 是 API key 或者 JWT，为空时不认证
+	Token string
+	// MaxRetries 是失败后的最大重试次数，0 表示不重试
+	MaxRetries int
+	// RetryWait 是第一次重试前等待的时间，之后每次翻倍
+	RetryWait time.Duration
+
+	// Files 操作上传的文件，Results 操作任务的结果
+	Files   *Storage
+	Results *Storage
+
+	base *url.URL // 服务器地址，通过注册中心转发时带有节点的路径
+}
+
+// New 创建一个客户端，baseURL 是服务器的地址，例如 http://localhost:4555
+func New(baseURL string) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("client: unsupported URL scheme %q", base.Scheme)
+	}
+	c := &Client{
+		HTTPClient: &http.Client{},
+		MaxRetries: DefaultMaxRetries,
+		RetryWait:  DefaultRetryWait,
+	}
+	c.setBase(base)
+	return c, nil
+}
+
+func (c *Client) setBase(base *url.URL) {
+	c.base = base
+	c.Files = &Storage{c: c, kind: "files"}
+	c.Results = &Storage{c: c, kind: "results"}
+}
+
+// BaseURL 返回服务器的地址
+func (c *Client) BaseURL() string { return c.base.String() }
+
+// ************************************************  错误  ************************************************
+
+// APIError 是服务器返回的错误，对应服务器的 {"code", "message", "details"}
+type APIError struct {
+	StatusCode int         `json:"-"`
+	Code       string      `json:"code"`
+	Message    string      `json:"message"`
+	Details    interface{} `json:"details,omitempty"`
+	RequestID  string      `json:"-"` // 服务器日志中的请求ID
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+	if e.Details != nil {
+		msg += fmt.Sprintf(" (%v)", e.Details)
+	}
+	return msg
+}
+
+// StatusCode 返回 err 中服务器返回的状态码，不是 APIError 时返回 0
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound 判断 err 是不是服务器返回的 404
+func IsNotFound(err error) bool { return StatusCode(err) == http.StatusNotFound }
+
+// 从响应中读取错误，不是JSON时使用响应体作为错误信息
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &APIError{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Code = strings.ToLower(strings.ReplaceAll(http.StatusText(resp.StatusCode), " ", "_"))
+		apiErr.Message = strings.TrimSpace(string(body))
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+	return apiErr
+}
+
+// ************************************************  请求  ************************************************
+
+// 一个请求，body 在每次重试时重新创建
+type request struct {
+	method      string
+	path        string // 相对于服务器地址，例如 /api/v1/files
+	query       url.Values
+	header      http.Header
+	body        func() (io.Reader, error)
+	contentType string
+	retry       bool // 非幂等的请求默认不重试
+}
+
+func newRequest(method, path string) *request {
+	return &request{method: method, path: path, query: url.Values{}, header: http.Header{}}
+}
+
+// 请求体是 JSON
+func (r *request) json(v interface{}) *request {
+	data, err := json.Marshal(v)
+	r.body = func() (io.Reader, error) { return bytes.NewReader(data), err }
+	r.contentType = "application/json"
+	return r
+}
+
+// 接口的路径，每一段分别转义，name 中的斜杠保留，例如 files/project-a/input.csv
+func apiPath(parts ...string) string {
+	var b strings.Builder
+	b.WriteString(apiPrefix)
+	for _, part := range parts {
+		for _, seg := range strings.Split(strings.Trim(part, "/"), "/") {
+			if seg == "" {
+				continue
+			}
+			b.WriteByte('/')
+			b.WriteString(url.PathEscape(seg))
+		}
+	}
+	return b.String()
+}
+
+// 请求的完整地址，path 已经转义过
+func (c *Client) url(path string, query url.Values) string {
+	u := c.base.String() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// 幂等的请求可以重试
+func (r *request) retryable() bool {
+	switch r.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return r.retry
+}
+
+// 这些状态码表示服务器暂时不可用，可以重试
+func retryStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do 发送一个请求，失败时按需要重试，返回 2xx 或 3xx 的响应，其它状态码返回 *APIError
+// 调用者需要关闭响应体
+func (c *Client) do(ctx context.Context, req *request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, nil
+		}
+
+		var retryAfter time.Duration
+		if err == nil {
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			if !retryStatus(resp.StatusCode) {
+				return nil, readError(resp)
+			}
+			err = readError(resp)
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if !req.retryable() || attempt >= c.MaxRetries {
+			return nil, err
+		}
+		if werr := c.wait(ctx, attempt, retryAfter); werr != nil {
+			return nil, werr
+		}
+	}
+}
+
+// 发送一次请求
+func (c *Client) send(ctx context.Context, req *request) (*http.Response, error) {
+	var body io.Reader
+	if req.body != nil {
+		var err error
+		if body, err = req.body(); err != nil {
+			return nil, err
+		}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.url(req.path, req.query), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	c.authorize(httpReq.Header)
+	return c.HTTPClient.Do(httpReq)
+}
+
+// 设置认证头
+func (c *Client) authorize(header http.Header) {
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// 第 attempt 次失败后等待，Retry-After 比退避时间长时使用 Retry-After
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter time.Duration) error {
+	delay := c.RetryWait << attempt
+	if delay < 0 || delay > maxRetryWait {
+		delay = maxRetryWait
+	}
+	if retryAfter > delay {
+		delay = min(retryAfter, maxRetryWait)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Retry-After 可以是秒数或者时间
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// 判断读取响应体时的错误是不是网络中断，可以重新请求
+func transientError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// 发送请求并把JSON响应解析到 out，out 为 nil 时丢弃响应体
+func (c *Client) doJSON(ctx context.Context, req *request, out interface{}) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return decodeJSON(resp, out)
+}
+
+// 解析JSON响应
+func decodeJSON(resp *http.Response, out interface{}) error {
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decoding %s %s response: %w", resp.Request.Method, resp.Request.URL.Path, err)
+	}
+	return nil
+}
+
+// ************************************************  进度  ************************************************
+
+// 读取时报告进度
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func newProgressReader(r io.Reader, total int64, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	progress(0, total)
+	return &progressReader{r: r, total: total, progress: progress}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+// ************************************************  服务器  ************************************************
+
+// Ping 检查服务器是否正常工作
+func (c *Client) Ping(ctx context.Context) error {
+	return c.doJSON(ctx, newRequest(http.MethodGet, apiPrefix), nil)
+}
+
+// Registration 返回服务器在注册中心的注册状态
+func (c *Client) Registration(ctx context.Context) (*RegistrationStatus, error) {
+	var status RegistrationStatus
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("registration")), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
@@ -0,0 +1,923 @@
+package client_test
+
+import (
+	"UPC-GO/api"
+	"UPC-GO/client"
+	"UPC-GO/config"
+	"UPC-GO/logging"
+	"UPC-GO/registry"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fpath "path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试服务器使用的目录
+type testServer struct {
+	*client.Client
+	url     string
+	uploads string
+	results string
+	tus     string
+}
+
+// 启动一个运行真实接口的测试服务器，wrap 可以在接口前面加上模拟故障的中间件
+func newTestServer(t *testing.T, configure func(cfg *config.Config), wrap func(http.Handler) http.Handler) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Storage.Uploads = fpath.Join(dir, "uploads")
+	cfg.Storage.Results = fpath.Join(dir, "results")
+	cfg.Storage.Jobs = fpath.Join(dir, "jobs")
+	cfg.Storage.Tus = fpath.Join(dir, "tus")
+	if configure != nil {
+		configure(cfg)
+	}
+	if err := api.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := api.NewRouter()
+	api.Routes(rt)
+	reg := registry.New(time.Minute, "test-token", nil)
+	reg.JWTSecret = []byte(cfg.Auth.JWTSecret)
+	reg.Register(rt)
+	var handler http.Handler = api.AuthMiddleware(rt)
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	srv := httptest.NewServer(logging.Middleware(handler))
+	t.Cleanup(srv.Close)
+
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RetryWait = time.Millisecond
+	return &testServer{Client: c, url: srv.URL, uploads: cfg.Storage.Uploads, results: cfg.Storage.Results, tus: cfg.Storage.Tus}
+}
+
+// 在临时目录中创建一个本地文件
+func writeLocal(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	p := fpath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestFiles(t *testing.T) {
+	s := newTestServer(t, nil, nil)
+	ctx := context.Background()
+
+	a := writeLocal(t, "a.csv", []byte("x,y\n1,2\n"))
+	b := writeLocal(t, "b.txt", bytes.Repeat([]byte("b"), 1000))
+	var last int64
+	uploaded, err := s.Upload(ctx, "project-a", []string{a, b}, func(done, total int64) { last = done })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(uploaded, ",") != "project-a/a.csv,project-a/b.txt" {
+		t.Errorf("uploaded = %v", uploaded)
+	}
+	if last != 1008 {
+		t.Errorf("upload progress ended at %d, want 1008", last)
+	}
+
+	list, err := s.Files.List(ctx, "project-a", &client.ListOptions{Sort: "size", Desc: true, PageSize: 1, Page: 1, Digest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 || len(list.Entries) != 1 || list.Entries[0].Name != "b.txt" || list.Entries[0].Sha256 == "" {
+		t.Errorf("list = %+v", list)
+	}
+	// 很大的 page 和 pageSize 不会溢出，超出范围的页是空的
+	for _, opts := range []*client.ListOptions{{Page: 2, PageSize: math.MaxInt}, {Page: math.MaxInt, PageSize: 2}, {Page: math.MaxInt, PageSize: math.MaxInt}} {
+		list, err := s.Files.List(ctx, "project-a", opts)
+		if err != nil || list.Total != 2 || len(list.Entries) != 0 {
+			t.Errorf("page %d of %d: %+v, %v", opts.Page, opts.PageSize, list, err)
+		}
+	}
+	if _, err := s.Files.List(ctx, "project-a/a.csv", nil); err == nil {
+		t.Error("listing a file should fail")
+	}
+
+	stat, err := s.Files.Stat(ctx, "project-a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size != 1000 || stat.ETag == "" {
+		t.Errorf("stat = %+v", stat)
+	}
+
+	var buf bytes.Buffer
+	if _, err := s.Files.Download(ctx, "project-a/a.csv", &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "x,y\n1,2\n" {
+		t.Errorf("downloaded %q", buf.String())
+	}
+
+	// 打包下载
+	buf.Reset()
+	if _, err := s.Files.DownloadArchive(ctx, []string{"project-a"}, client.ArchiveZip, &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "project-a/,project-a/a.csv,project-a/b.txt" {
+		t.Errorf("archive contains %v", names)
+	}
+
+	// 文件夹和删除
+	if err := s.Results.Mkdir(ctx, "run-1/out"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fpath.Join(s.results, "run-1", "out")); err != nil {
+		t.Errorf("folder not created: %v", err)
+	}
+	if err := s.Files.Delete(ctx, "project-a/a.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Files.Download(ctx, "project-a/a.csv", io.Discard, nil); !client.IsNotFound(err) {
+		t.Errorf("download of a deleted file: %v, want 404", err)
+	}
+	if err := s.Files.DeleteMany(ctx, []string{"project-a/b.txt", "project-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := s.Files.List(ctx, "", nil); err != nil || len(list.Entries) != 0 {
+		t.Errorf("files after delete = %+v, %v", list, err)
+	}
+}
+
+// 第一次下载在中途断开，客户端使用 Range 继续下载
+func TestDownloadResumes(t *testing.T) {
+	var ranges []string
+	var cut atomic.Bool
+	s := newTestServer(t, nil, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !strings.Contains(r.URL.Path, "/files/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ranges = append(ranges, r.Header.Get("Range"))
+			if cut.CompareAndSwap(false, true) {
+				next.ServeHTTP(&cutWriter{ResponseWriter: w, limit: 300}, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	os.MkdirAll(s.uploads, 0o755)
+	os.WriteFile(fpath.Join(s.uploads, "big.bin"), data, 0o644)
+
+	var buf bytes.Buffer
+	var last, lastTotal int64
+	n, err := s.Files.Download(context.Background(), "big.bin", &buf, func(done, total int64) { last, lastTotal = done, total })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("downloaded %d bytes, content equal = %v", n, bytes.Equal(buf.Bytes(), data))
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=300-" {
+		t.Errorf("Range headers = %q", ranges)
+	}
+	if last != 1000 || lastTotal != 1000 {
+		t.Errorf("progress ended at %d/%d", last, lastTotal)
+	}
+}
+
+// 无法安全地继续下载时返回错误，而不是再发送一个 Range 请求
+func TestDownloadNotResumable(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(w http.ResponseWriter, r *http.Request, file string) http.ResponseWriter
+		want   string
+	}{
+		{
+			// 第一次响应没有 ETag
+			name: "no etag",
+			modify: func(w http.ResponseWriter, r *http.Request, file string) http.ResponseWriter {
+				return &noETagWriter{ResponseWriter: w}
+			},
+			want: "without an ETag",
+		},
+		{
+			// 文件被截断，服务器忽略 If-Range 后返回 416
+			name: "truncated",
+			modify: func(w http.ResponseWriter, r *http.Request, file string) http.ResponseWriter {
+				if r.Header.Get("Range") != "" {
+					r.Header.Del("If-Range")
+					os.Truncate(file, 100)
+				}
+				return w
+			},
+			want: "is now shorter than the 300 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ranges []string
+			var cut atomic.Bool
+			var file string
+			s := newTestServer(t, nil, func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method != http.MethodGet || !strings.Contains(r.URL.Path, "/files/") {
+						next.ServeHTTP(w, r)
+						return
+					}
+					ranges = append(ranges, r.Header.Get("Range"))
+					w = tt.modify(w, r, file)
+					if cut.CompareAndSwap(false, true) {
+						next.ServeHTTP(&cutWriter{ResponseWriter: w, limit: 300}, r)
+						return
+					}
+					next.ServeHTTP(w, r)
+				})
+			})
+			file = fpath.Join(s.uploads, "big.bin")
+			os.MkdirAll(s.uploads, 0o755)
+			os.WriteFile(file, bytes.Repeat([]byte("0123456789"), 100), 0o644)
+
+			n, err := s.Files.Download(context.Background(), "big.bin", io.Discard, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Download error = %v, want %q", err, tt.want)
+			}
+			if n != 300 {
+				t.Errorf("downloaded %d bytes, want 300", n)
+			}
+			if tt.name == "no etag" && len(ranges) != 1 {
+				t.Errorf("Range headers = %q, want only the first request", ranges)
+			}
+		})
+	}
+}
+
+// 删除响应中的 ETag
+type noETagWriter struct {
+	http.ResponseWriter
+}
+
+func (w *noETagWriter) WriteHeader(code int) {
+	w.Header().Del("ETag")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *noETagWriter) Flush() { w.ResponseWriter.(http.Flusher).Flush() }
+
+// 写入 limit 个字节后断开连接
+type cutWriter struct {
+	http.ResponseWriter
+	limit   int
+	written int
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		p = p[:w.limit-w.written]
+		w.ResponseWriter.Write(p)
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.written += len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// 第一次 PATCH 在中途断开，客户端查询 offset 后继续上传
+func TestUploadResumable(t *testing.T) {
+	var patches []string
+	var cut atomic.Bool
+	s := newTestServer(t, nil, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPatch {
+				patches = append(patches, r.Header.Get("Upload-Offset"))
+				if cut.CompareAndSwap(false, true) {
+					// 服务器只收到一部分数据，然后连接断开
+					r.Body = io.NopCloser(io.LimitReader(r.Body, 4096))
+					next.ServeHTTP(httptest.NewRecorder(), r)
+					conn, _, _ := http.NewResponseController(w).Hijack()
+					conn.Close()
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	data := bytes.Repeat([]byte("resumable "), 2000)
+	local := writeLocal(t, "data.txt", data)
+	name, err := s.UploadResumable(context.Background(), "tus-dir", local, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "tus-dir/data.txt" {
+		t.Errorf("name = %q", name)
+	}
+	got, err := os.ReadFile(fpath.Join(s.uploads, "tus-dir", "data.txt"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("uploaded file differs: %d bytes, %v", len(got), err)
+	}
+	if len(patches) != 2 || patches[0] != "0" || patches[1] != "4096" {
+		t.Errorf("PATCH offsets = %q", patches)
+	}
+	// 完成后删除上传的状态
+	if left, _ := os.ReadDir(s.tus); len(left) != 0 {
+		t.Errorf("%d files left in the tus folder after the upload completed", len(left))
+	}
+}
+
+// 超过 limits.uploadExpiry 没有继续的上传不能再继续
+func TestUploadExpiry(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) { cfg.Limits.UploadExpiry = config.Duration(50 * time.Millisecond) }, nil)
+
+	tus := func(method, path string, header map[string]string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, s.url+path, nil)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	options := tus(http.MethodOptions, "/api/v1/tus", nil)
+	if !strings.Contains(options.Header.Get("Tus-Extension"), "expiration") {
+		t.Errorf("Tus-Extension = %q, want expiration", options.Header.Get("Tus-Extension"))
+	}
+	created := tus(http.MethodPost, "/api/v1/tus", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("late.txt")),
+	})
+	if created.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d", created.StatusCode)
+	}
+	if _, err := http.ParseTime(created.Header.Get("Upload-Expires")); err != nil {
+		t.Errorf("Upload-Expires %q: %v", created.Header.Get("Upload-Expires"), err)
+	}
+	location := created.Header.Get("Location")
+	if head := tus(http.MethodHead, location, nil); head.StatusCode != http.StatusOK {
+		t.Fatalf("HEAD before expiry: status %d", head.StatusCode)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if head := tus(http.MethodHead, location, nil); head.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD after expiry: status %d, want 404", head.StatusCode)
+	}
+
+	// 不存在的上传返回 404
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		resp := tus(method, "/api/v1/tus/0123456789abcdef", map[string]string{"Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"})
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s unknown upload: status %d, want 404", method, resp.StatusCode)
+		}
+	}
+}
+
+// 503 会重试，404 不会重试
+func TestRetries(t *testing.T) {
+	var failures, requests atomic.Int32
+	failures.Store(2)
+	s := newTestServer(t, nil, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			if failures.Add(-1) >= 0 {
+				w.Header().Set("Retry-After", "0")
+				api.WriteError(w, http.StatusServiceUnavailable, "Try again", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	ctx := context.Background()
+
+	if _, err := s.Files.List(ctx, "", nil); err != nil {
+		t.Fatalf("list after two 503: %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("requests = %d, want 3", requests.Load())
+	}
+
+	requests.Store(0)
+	_, err := s.GetBuild(ctx, "missing")
+	if !client.IsNotFound(err) || requests.Load() != 1 {
+		t.Errorf("GetBuild missing: %v after %d requests, want one 404", err, requests.Load())
+	}
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "not_found" || apiErr.RequestID == "" {
+		t.Errorf("error = %#v", err)
+	}
+
+	// 不重试时直接返回 503
+	failures.Store(1)
+	s.MaxRetries = 0
+	if _, err := s.ListBuilds(ctx); client.StatusCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("without retries: %v, want 503", err)
+	}
+
+	// context 取消时不再重试
+	failures.Store(100)
+	s.MaxRetries = 100
+	s.RetryWait = time.Hour
+	cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.ListBuilds(cancelCtx); err != context.DeadlineExceeded {
+		t.Errorf("canceled: %v, want deadline exceeded", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.APIKeys = []config.APIKey{{Key: "viewer-key", Role: "viewer"}}
+	}, nil)
+	ctx := context.Background()
+
+	if _, err := s.ListBuilds(ctx); client.StatusCode(err) != http.StatusUnauthorized {
+		t.Errorf("without token: %v, want 401", err)
+	}
+	s.Token = "viewer-key"
+	if _, err := s.ListBuilds(ctx); err != nil {
+		t.Errorf("with token: %v", err)
+	}
+	if err := s.Files.Mkdir(ctx, "x"); client.StatusCode(err) != http.StatusForbidden {
+		t.Errorf("viewer mkdir: %v, want 403", err)
+	}
+}
+
+func TestFollowBuild(t *testing.T) {
+	// 用一个脚本代替 pack，检查 --path 是解压出来的文件夹
+	pack := writeLocal(t, "pack", []byte("#!/bin/sh\necho \"fake pack $1 $2\"\ntest -f \"$4/index.js\"\n"))
+	os.Chmod(pack, 0o755)
+	s := newTestServer(t, func(cfg *config.Config) { cfg.Builder.Pack = pack }, nil)
+	ctx := context.Background()
+
+	// zip 中的文件夹和 zip 文件同名
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("app/index.js")
+	f.Write([]byte("console.log('hi')\n"))
+	zw.Close()
+	if _, err := s.Upload(ctx, "", []string{writeLocal(t, "app.zip", buf.Bytes())}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 和zip同名的文件夹是用户的文件，构建不能删除它
+	if _, err := s.Upload(ctx, "app", []string{writeLocal(t, "keep.txt", []byte("keep"))}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	build, err := s.Build(ctx, "app.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	final, err := s.FollowBuild(ctx, build.Id, &logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final.Status != client.BuildSucceeded {
+		t.Errorf("status = %s (%s), logs:\n%s", final.Status, final.Error, logs.String())
+	}
+	if !strings.Contains(logs.String(), "fake pack build "+final.Image) || !strings.Contains(logs.String(), "Build success") {
+		t.Errorf("logs:\n%s", logs.String())
+	}
+
+	if _, err := os.Stat(fpath.Join(s.uploads, "app", "keep.txt")); err != nil {
+		t.Errorf("build removed the user folder next to the zip: %v", err)
+	}
+
+	builds, err := s.ListBuilds(ctx)
+	if err != nil || len(builds) == 0 || builds[0].Id != build.Id {
+		t.Errorf("builds = %+v, %v", builds, err)
+	}
+	if _, err := s.CancelBuild(ctx, build.Id); client.StatusCode(err) != http.StatusConflict {
+		t.Errorf("cancel finished build: %v, want 409", err)
+	}
+	if _, err := s.Build(ctx, "missing.zip"); !client.IsNotFound(err) {
+		t.Errorf("build missing zip: %v, want 404", err)
+	}
+
+	// zip文件名不是合法的镜像名称时不加入队列
+	if _, err := s.Upload(ctx, "", []string{writeLocal(t, "my app+1.zip", buf.Bytes())}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Build(ctx, "my app+1.zip"); client.StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("build invalid image name: %v, want 400", err)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	s := newTestServer(t, nil, nil)
+	ctx := context.Background()
+
+	term, err := s.OpenTerminal(ctx, &client.TerminalOptions{Name: "test", Cols: 100, Rows: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer term.Close()
+
+	if _, err := term.Write([]byte("echo upc-$((40+2))\n")); err != nil {
+		t.Fatal(err)
+	}
+	output := readUntil(t, term, "upc-42")
+	if !strings.Contains(output, "upc-42") {
+		t.Fatalf("terminal output %q", output)
+	}
+
+	if err := term.Resize(120, 40); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	var info *client.SessionInfo
+	for time.Now().Before(deadline) {
+		if info, err = s.GetTerminal(ctx, term.ID); err == nil && info.Cols == 120 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info == nil || info.Cols != 120 || info.Rows != 40 || info.Name != "test" {
+		t.Errorf("session = %+v, %v", info, err)
+	}
+
+	sessions, err := s.ListTerminals(ctx)
+	if err != nil || len(sessions) == 0 {
+		t.Errorf("sessions = %+v, %v", sessions, err)
+	}
+	if err := s.KillTerminal(ctx, term.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, term); err != nil {
+		t.Errorf("read after kill: %v", err)
+	}
+	if term.ExitReason() == "" {
+		t.Error("no exit reason after kill")
+	}
+	if _, err := s.OpenTerminal(ctx, &client.TerminalOptions{Session: "missing"}); err == nil {
+		t.Error("attaching to a missing session should fail")
+	}
+}
+
+// 没有 Keep 的会话在断开后结束，会话数量不能超过 limits.maxTerminalSessions
+func TestTerminalKeep(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	s := newTestServer(t, func(cfg *config.Config) { cfg.Limits.MaxTerminalSessions = 1 }, nil)
+	ctx := context.Background()
+
+	// 等待会话结束或者保留
+	sessionExists := func(id string, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			_, err := s.GetTerminal(ctx, id)
+			if (err == nil) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("session %s exists = %v, want %v", id, err == nil, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	term, err := s.OpenTerminal(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OpenTerminal(ctx, nil); err == nil {
+		t.Error("opening more sessions than maxTerminalSessions should fail")
+	}
+	term.Close()
+	sessionExists(term.ID, false)
+
+	term, err = s.OpenTerminal(ctx, &client.TerminalOptions{Keep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Close()
+	sessionExists(term.ID, true)
+	again, err := s.OpenTerminal(ctx, &client.TerminalOptions{Session: term.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+	if err := s.KillTerminal(ctx, term.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 终端会话只对创建者和 admin 可见
+func TestTerminalOwner(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.APIKeys = []config.APIKey{{Key: "root-key", Role: "admin"}, {Key: "boss-key", Role: "admin"}, {Key: "oper-key", Role: "operator"}}
+	}, nil)
+	ctx := context.Background()
+
+	s.Token = "root-key"
+	term, err := s.OpenTerminal(ctx, &client.TerminalOptions{Keep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer term.Close()
+	info, err := s.GetTerminal(ctx, term.ID)
+	if err != nil || info.Owner == "" {
+		t.Fatalf("owner = %+v, %v", info, err)
+	}
+
+	// 其它用户看不到这个会话
+	s.Token = "oper-key"
+	if sessions, err := s.ListTerminals(ctx); err != nil || len(sessions) != 0 {
+		t.Errorf("operator sessions = %+v, %v, want none", sessions, err)
+	}
+	if _, err := s.GetTerminal(ctx, term.ID); !client.IsNotFound(err) {
+		t.Errorf("operator get: %v, want 404", err)
+	}
+	if err := s.KillTerminal(ctx, term.ID); !client.IsNotFound(err) {
+		t.Errorf("operator kill: %v, want 404", err)
+	}
+
+	// admin 可以看到所有会话
+	s.Token = "boss-key"
+	if sessions, err := s.ListTerminals(ctx); err != nil || len(sessions) != 1 {
+		t.Errorf("admin sessions = %+v, %v", sessions, err)
+	}
+	if err := s.KillTerminal(ctx, term.ID); err != nil {
+		t.Errorf("admin kill: %v", err)
+	}
+}
+
+// 读取终端输出直到出现 want
+func readUntil(t *testing.T, r io.Reader, want string) string {
+	t.Helper()
+	done := make(chan string, 1)
+	go func() {
+		var out []byte
+		buf := make([]byte, 1024)
+		for !bytes.Contains(out, []byte(want)) {
+			n, err := r.Read(buf)
+			out = append(out, buf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		done <- string(out)
+	}()
+	select {
+	case out := <-done:
+		return out
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+		return ""
+	}
+}
+
+// docker 在拉取的输出流中返回错误，不使用流式返回时转换为 404 或 502
+func TestPullImageError(t *testing.T) {
+	docker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_ping") {
+			w.Header().Set("API-Version", "1.45")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch image := r.URL.Query().Get("fromImage"); {
+		case strings.Contains(image, "missing"):
+			fmt.Fprintln(w, `{"status":"Pulling from library/missing"}`)
+			fmt.Fprintln(w, `{"errorDetail":{"message":"manifest for missing:latest not found: manifest unknown"},"error":"manifest for missing:latest not found: manifest unknown"}`)
+		case strings.Contains(image, "broken"):
+			fmt.Fprintln(w, `{"errorDetail":{"message":"Get \"https://registry-1.docker.io/v2/\": dial tcp: connection refused"},"error":"connection refused"}`)
+		default:
+			fmt.Fprintln(w, `{"status":"Status: Downloaded newer image for `+image+`:latest"}`)
+		}
+	}))
+	defer docker.Close()
+	t.Setenv("DOCKER_HOST", "tcp://"+docker.Listener.Addr().String())
+	s := newTestServer(t, nil, nil)
+	ctx := context.Background()
+
+	if err := s.PullImage(ctx, "alpine", nil); err != nil {
+		t.Errorf("pull alpine: %v", err)
+	}
+	if err := s.PullImage(ctx, "missing", nil); !client.IsNotFound(err) {
+		t.Errorf("pull missing: %v, want 404", err)
+	}
+	if err := s.PullImage(ctx, "broken", nil); client.StatusCode(err) != http.StatusBadGateway {
+		t.Errorf("pull broken: %v, want 502", err)
+	}
+}
+
+// 通过注册中心转发到节点，这里节点就是服务器自己
+func TestNodes(t *testing.T) {
+	s := newTestServer(t, nil, nil)
+	ctx := context.Background()
+
+	// 注册需要注册中心的令牌
+	register := func(token string) int {
+		body, _ := json.Marshal(map[string]string{"_id": "test node", "url": s.url})
+		req, _ := http.NewRequest(http.MethodPost, s.url+"/backend/register-service", bytes.NewReader(body))
+		req.Header.Set("X-Registry-Token", token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := register("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("register with a wrong token = %d, want 401", code)
+	}
+	if code := register("test-token"); code != http.StatusOK {
+		t.Fatalf("register = %d", code)
+	}
+
+	nodes, err := s.Nodes(ctx)
+	if err != nil || len(nodes) != 1 || nodes[0].ID != "test node" {
+		t.Fatalf("nodes = %+v, %v", nodes, err)
+	}
+	if node, err := s.GetNode(ctx, nodes[0].Key); err != nil || node.URL != s.url {
+		t.Errorf("node = %+v, %v", node, err)
+	}
+
+	node := s.OnNode(nodes[0].Key)
+	local := writeLocal(t, "via-node.txt", []byte("proxied"))
+	if _, err := node.UploadResumable(ctx, "", local, nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := node.Files.Download(ctx, "via-node.txt", &buf, nil); err != nil || buf.String() != "proxied" {
+		t.Errorf("download via node = %q, %v", buf.String(), err)
+	}
+	if status, err := node.Registration(ctx); err != nil || status.State == "" {
+		t.Errorf("registration via node = %+v, %v", status, err)
+	}
+
+	// 只转发节点的 /api 和 /ws
+	resp, err := http.Get(s.url + "/api/v1/nodes/" + nodes[0].Key + "/static/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("proxy to /static = %d, want 404", resp.StatusCode)
+	}
+}
+
+// 在注册中心注册一个节点，返回节点的 key
+func registerNode(t *testing.T, s *testServer, id, nodeURL string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"_id": id, "url": nodeURL})
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/backend/register-service", bytes.NewReader(body))
+	req.Header.Set("X-Registry-Token", "test-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("register %s = %d", id, resp.StatusCode)
+	}
+	nodes, err := s.Nodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		if node.ID == id {
+			return node.Key
+		}
+	}
+	t.Fatalf("node %s is not registered", id)
+	return ""
+}
+
+// 一个记录收到的 Authorization 头的节点
+func authRecorder(t *testing.T) (string, func() string) {
+	var mu sync.Mutex
+	var auth string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth = r.Header.Get("Authorization")
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(node.Close)
+	return node.URL, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return auth
+	}
+}
+
+// 节点启用了认证时，注册中心转发的请求带上签名的 JWT，保留调用者的角色
+func TestNodesWithJWT(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.APIKeys = []config.APIKey{{Key: "root-key", Role: "admin"}, {Key: "view-key", Role: "viewer"}}
+		cfg.Auth.JWTSecret = "jwt-secret"
+	}, nil)
+	ctx := context.Background()
+	s.Token = "root-key"
+
+	// 节点就是服务器自己，使用同样的认证配置
+	node := s.OnNode(registerNode(t, s, "self", s.url))
+	if err := node.Files.Mkdir(ctx, "via-node"); err != nil {
+		t.Fatalf("admin mkdir via node: %v", err)
+	}
+	node.Token = "view-key"
+	if _, err := node.Files.List(ctx, "", nil); err != nil {
+		t.Errorf("viewer list via node: %v", err)
+	}
+	if err := node.Files.Mkdir(ctx, "viewer-dir"); client.StatusCode(err) != http.StatusForbidden {
+		t.Errorf("viewer mkdir via node: %v, want 403", err)
+	}
+
+	// 节点收到的是注册中心签名的 JWT，不是调用者的 API key
+	s.Token = "view-key"
+	nodeURL, received := authRecorder(t)
+	fake := s.OnNode(registerNode(t, s, "fake", nodeURL))
+	if _, err := fake.Files.List(ctx, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	token, ok := strings.CutPrefix(received(), "Bearer ")
+	if !ok || token == "view-key" {
+		t.Fatalf("node received Authorization %q, want a JWT", received())
+	}
+	principal, err := (&api.JWTAuthenticator{Secret: []byte("jwt-secret")}).Authenticate(token)
+	if err != nil || principal.Role != api.RoleViewer {
+		t.Errorf("forwarded JWT = %+v, %v", principal, err)
+	}
+}
+
+// 没有 JWT 密钥时原样转发调用者的 token，节点和注册中心使用相同的 API key
+func TestNodesWithAPIKeys(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.APIKeys = []config.APIKey{{Key: "root-key", Role: "admin"}}
+	}, nil)
+	ctx := context.Background()
+	s.Token = "root-key"
+
+	node := s.OnNode(registerNode(t, s, "self", s.url))
+	if err := node.Files.Mkdir(ctx, "via-node"); err != nil {
+		t.Fatalf("mkdir via node: %v", err)
+	}
+
+	nodeURL, received := authRecorder(t)
+	fake := s.OnNode(registerNode(t, s, "fake", nodeURL))
+	if _, err := fake.Files.List(ctx, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if received() != "Bearer root-key" {
+		t.Errorf("node received Authorization %q, want the caller's token", received())
+	}
+}
+
+// 没有 docker 时跳过
+func TestImages(t *testing.T) {
+	s := newTestServer(t, nil, nil)
+	s.MaxRetries = 0
+	ctx := context.Background()
+
+	images, err := s.ListImages(ctx)
+	if client.StatusCode(err) == http.StatusBadGateway {
+		t.Skip("docker is not available:", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InspectImage(ctx, "upc-missing/image:none"); !client.IsNotFound(err) {
+		t.Errorf("inspect missing image: %v, want 404", err)
+	}
+	for _, image := range images {
+		details, err := s.InspectImage(ctx, image)
+		if err != nil || len(details.RepositoryTags) == 0 {
+			t.Errorf("inspect %s = %+v, %v", image, details, err)
+		}
+		break
+	}
+}
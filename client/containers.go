@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// ListContainers 返回容器的列表，all 为 false 时只返回运行中的容器
+func (c *Client) ListContainers(ctx context.Context, all bool) ([]ContainerSummary, error) {
+	req := newRequest(http.MethodGet, apiPath("containers"))
+	req.query.Set("all", strconv.FormatBool(all))
+	var containers []ContainerSummary
+	if err := c.doJSON(ctx, req, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// GetContainer 通过ID或名称返回一个容器
+func (c *Client) GetContainer(ctx context.Context, id string) (*ContainerSummary, error) {
+	// 服务器返回只有一个元素的数组
+	var containers []ContainerSummary
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("containers", id)), &containers); err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: "Container not found: " + id}
+	}
+	return &containers[0], nil
+}
+
+// CreateContainer 创建一个容器，start 为 true 时创建后立即启动
+func (c *Client) CreateContainer(ctx context.Context, create ContainerCreateRequest, start bool) (*ContainerSummary, error) {
+	req := newRequest(http.MethodPost, apiPath("containers")).json(create)
+	if start {
+		req.query.Set("start", "true")
+	}
+	var summary ContainerSummary
+	if err := c.doJSON(ctx, req, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// StartContainer 启动一个容器
+func (c *Client) StartContainer(ctx context.Context, id string) (*ContainerSummary, error) {
+	return c.containerAction(ctx, id, "start", -1)
+}
+
+// StopContainer 停止一个容器，timeout 是等待的秒数，小于 0 时使用 docker 的默认值
+func (c *Client) StopContainer(ctx context.Context, id string, timeout int) (*ContainerSummary, error) {
+	return c.containerAction(ctx, id, "stop", timeout)
+}
+
+// RestartContainer 重启一个容器，timeout 和 StopContainer 相同
+func (c *Client) RestartContainer(ctx context.Context, id string, timeout int) (*ContainerSummary, error) {
+	return c.containerAction(ctx, id, "restart", timeout)
+}
+
+func (c *Client) containerAction(ctx context.Context, id, action string, timeout int) (*ContainerSummary, error) {
+	req := newRequest(http.MethodPost, apiPath("containers", id, action))
+	req.retry = true // 重复执行的结果相同
+	if timeout >= 0 && action != "start" {
+		req.query.Set("timeout", strconv.Itoa(timeout))
+	}
+	var summary ContainerSummary
+	if err := c.doJSON(ctx, req, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// RemoveContainer 删除一个容器，force 为 true 时先结束运行中的容器
+func (c *Client) RemoveContainer(ctx context.Context, id string, force bool) error {
+	req := newRequest(http.MethodDelete, apiPath("containers", id))
+	if force {
+		req.query.Set("force", "true")
+	}
+	return c.doJSON(ctx, req, nil)
+}
+
+// RunJob 用一个 docker image 处理 uploads 中的文件，等待容器退出，结果保存到 results
+func (c *Client) RunJob(ctx context.Context, job JobRequest) (*JobResult, error) {
+	var result JobResult
+	if err := c.doJSON(ctx, newRequest(http.MethodPost, apiPath("jobs")).json(job), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,424 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	fpath "path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Storage 操作服务器上的一个存储目录，Client.Files 是上传的文件，Client.Results 是任务的结果
+// name 是相对于存储目录的路径，可以包含子文件夹，例如 project-a/input.csv
+type Storage struct {
+	c    *Client
+	kind string // files 或 results
+}
+
+// ListOptions 是列出文件时的过滤、排序和分页
+type ListOptions struct {
+	Sort     string   // name, size, time 或 type，默认 name
+	Desc     bool     // 倒序
+	Glob     string   // 按文件名通配符过滤，例如 *.csv
+	Ext      []string // 按扩展名过滤，例如 csv, png
+	Page     int      // 从 1 开始，0 表示不分页
+	PageSize int      // 每页的数量，默认 100
+	Digest   bool     // 同时返回 SHA-256
+}
+
+// FileList 是一个文件夹中的文件
+type FileList struct {
+	Entries []FileEntry
+	Total   int // 分页前的总数
+}
+
+// List 列出一个文件夹中的文件，dir 为空时列出存储目录本身，opts 可以为 nil
+func (s *Storage) List(ctx context.Context, dir string, opts *ListOptions) (*FileList, error) {
+	req := newRequest(http.MethodGet, apiPath(s.kind, dir))
+	req.query.Set("detail", "true")
+	if opts != nil {
+		if opts.Sort != "" {
+			req.query.Set("sort", opts.Sort)
+		}
+		if opts.Desc {
+			req.query.Set("order", "desc")
+		}
+		if opts.Glob != "" {
+			req.query.Set("glob", opts.Glob)
+		}
+		if len(opts.Ext) > 0 {
+			req.query.Set("ext", strings.Join(opts.Ext, ","))
+		}
+		if opts.Page > 0 {
+			req.query.Set("page", strconv.Itoa(opts.Page))
+		}
+		if opts.PageSize > 0 {
+			req.query.Set("pageSize", strconv.Itoa(opts.PageSize))
+		}
+		if opts.Digest {
+			req.query.Set("digest", "true")
+		}
+	}
+
+	resp, err := s.c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// 路径是一个文件时服务器直接返回文件内容
+	if resp.Header.Get("Content-Disposition") != "" {
+		return nil, fmt.Errorf("client: %s is not a folder", dir)
+	}
+
+	list := &FileList{}
+	if err := decodeJSON(resp, &list.Entries); err != nil {
+		return nil, err
+	}
+	list.Total = len(list.Entries)
+	if total, err := strconv.Atoi(resp.Header.Get("X-Total-Count")); err == nil {
+		list.Total = total
+	}
+	return list, nil
+}
+
+// FileStat 是 HEAD 请求返回的文件信息
+type FileStat struct {
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified string
+}
+
+// Stat 返回一个文件的大小和 ETag，不下载文件内容
+func (s *Storage) Stat(ctx context.Context, name string) (*FileStat, error) {
+	resp, err := s.c.do(ctx, newRequest(http.MethodHead, apiPath(s.kind, name)))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &FileStat{
+		Size:         resp.ContentLength,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// Download 把一个文件写入 w，返回写入的字节数
+// 连接中断时使用 Range 和 If-Range 从断开的位置继续下载，文件在下载过程中被修改或截断时返回错误
+// 服务器没有返回 ETag 时不会继续下载
+func (s *Storage) Download(ctx context.Context, name string, w io.Writer, progress ProgressFunc) (int64, error) {
+	var written int64
+	var etag string
+	for attempt := 0; ; attempt++ {
+		req := newRequest(http.MethodGet, apiPath(s.kind, name))
+		if written > 0 {
+			req.header.Set("Range", fmt.Sprintf("bytes=%d-", written))
+			req.header.Set("If-Range", etag)
+		}
+		resp, err := s.c.do(ctx, req)
+		if err != nil {
+			if written > 0 && StatusCode(err) == http.StatusRequestedRangeNotSatisfiable {
+				// 服务器上的文件比已经下载的部分短，说明文件被截断或替换了
+				return written, fmt.Errorf("client: %s is now shorter than the %d bytes already downloaded: %w", name, written, err)
+			}
+			return written, err
+		}
+
+		total := resp.ContentLength
+		switch {
+		case resp.StatusCode == http.StatusPartialContent:
+			total = written + resp.ContentLength
+		case written > 0:
+			// 文件已经变化，服务器返回了整个文件，已经写入的内容无法撤回
+			resp.Body.Close()
+			return written, fmt.Errorf("client: %s changed during download", name)
+		default:
+			etag = resp.Header.Get("ETag")
+		}
+
+		body := io.Reader(resp.Body)
+		if progress != nil {
+			body = &progressReader{r: resp.Body, done: written, total: total, progress: progress}
+		}
+		n, err := io.Copy(w, body)
+		resp.Body.Close()
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		if ctx.Err() != nil {
+			return written, ctx.Err()
+		}
+		// 只有网络中断可以继续，写入 w 失败时直接返回
+		if !transientError(err) || attempt >= s.c.MaxRetries {
+			return written, err
+		}
+		// 已经写入了一部分时需要 ETag 才能用 If-Range 继续，否则无法确认文件没有变化
+		if written > 0 && etag == "" {
+			return written, fmt.Errorf("client: cannot resume %s without an ETag: %w", name, err)
+		}
+		if err := s.c.wait(ctx, attempt, 0); err != nil {
+			return written, err
+		}
+	}
+}
+
+// DownloadFile 把一个文件下载到本地的 localPath
+func (s *Storage) DownloadFile(ctx context.Context, name, localPath string, progress ProgressFunc) error {
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Download(ctx, name, file, progress); err != nil {
+		file.Close()
+		os.Remove(localPath)
+		return err
+	}
+	return file.Close()
+}
+
+// 打包下载的格式
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// DownloadArchive 把多个文件或文件夹打包成 zip 或 tar.gz 写入 w，names 为空时打包全部文件
+func (s *Storage) DownloadArchive(ctx context.Context, names []string, format string, w io.Writer, progress ProgressFunc) (int64, error) {
+	if names == nil {
+		names = []string{}
+	}
+	req := newRequest(http.MethodPost, apiPath(s.kind, "download")).json(map[string]interface{}{
+		"fileNames": names,
+		"format":    format,
+	})
+	// 只是读取文件，可以重试
+	req.retry = true
+	resp, err := s.c.do(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// 压缩包边打包边发送，大小未知
+	return io.Copy(w, newProgressReader(resp.Body, -1, progress))
+}
+
+// Delete 删除一个文件或文件夹
+func (s *Storage) Delete(ctx context.Context, name string) error {
+	if strings.Trim(name, "/") == "" {
+		return errors.New("client: no file name")
+	}
+	return s.c.doJSON(ctx, newRequest(http.MethodDelete, apiPath(s.kind, name)), nil)
+}
+
+// DeleteMany 删除多个文件或文件夹，遇到不存在的文件时停止
+func (s *Storage) DeleteMany(ctx context.Context, names []string) error {
+	body := map[string]interface{}{"files": map[string][]string{"fileNames": names}}
+	return s.c.doJSON(ctx, newRequest(http.MethodDelete, apiPath(s.kind)).json(body), nil)
+}
+
+// Mkdir 创建一个文件夹，父文件夹不存在时一起创建
+func (s *Storage) Mkdir(ctx context.Context, dir string) error {
+	req := newRequest(http.MethodPost, apiPath(s.kind, dir))
+	req.query.Set("mkdir", "")
+	req.retry = true
+	return s.c.doJSON(ctx, req, nil)
+}
+
+// ************************************************  上传  ************************************************
+
+// Upload 把本地的文件上传到 uploads 中的 dir 文件夹，dir 为空时上传到 uploads，返回文件在服务器上的路径
+// 所有文件在一个 multipart 请求中上传，大文件使用 UploadResumable
+func (c *Client) Upload(ctx context.Context, dir string, localPaths []string, progress ProgressFunc) ([]string, error) {
+	if len(localPaths) == 0 {
+		return nil, errors.New("client: no files to upload")
+	}
+	var total int64
+	for _, p := range localPaths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("client: %s is a folder", p)
+		}
+		total += info.Size()
+	}
+
+	// 每次重试使用相同的 boundary，这样 Content-Type 不变
+	boundary := randomBoundary()
+	req := newRequest(http.MethodPost, apiPath("upload"))
+	if dir != "" {
+		req.query.Set("dir", dir)
+	}
+	req.contentType = "multipart/form-data; boundary=" + boundary
+	req.retry = true // 重新上传会覆盖同名文件
+	req.body = func() (io.Reader, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			mw := multipart.NewWriter(pw)
+			mw.SetBoundary(boundary)
+			pw.CloseWithError(writeFiles(mw, localPaths, total, progress))
+		}()
+		return pr, nil
+	}
+
+	var uploaded []string
+	if err := c.doJSON(ctx, req, &uploaded); err != nil {
+		return nil, err
+	}
+	return uploaded, nil
+}
+
+// 把文件写入 multipart 请求体
+func writeFiles(mw *multipart.Writer, localPaths []string, total int64, progress ProgressFunc) error {
+	var done int64
+	if progress != nil {
+		progress(0, total)
+	}
+	for _, p := range localPaths {
+		part, err := mw.CreateFormFile("file", fpath.Base(p))
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		var r io.Reader = file
+		if progress != nil {
+			r = &progressReader{r: file, done: done, total: total, progress: progress}
+		}
+		n, err := io.Copy(part, r)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		done += n
+	}
+	return mw.Close()
+}
+
+func randomBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "upc-" + hex.EncodeToString(b)
+}
+
+// tus 协议的版本和每次 PATCH 上传的最大字节数
+const (
+	tusVersion   = "1.0.0"
+	tusChunkSize = 16 << 20
+)
+
+// UploadResumable 使用 tus 断点续传协议上传一个本地文件到 uploads 中的 dir 文件夹，返回文件在服务器上的路径
+// 连接中断时查询服务器已经收到的字节数，从那里继续上传
+func (c *Client) UploadResumable(ctx context.Context, dir, localPath string, progress ProgressFunc) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+	name := fpath.Base(localPath)
+
+	// 创建上传
+	create := newRequest(http.MethodPost, apiPath("tus"))
+	create.header.Set("Tus-Resumable", tusVersion)
+	create.header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte(name))
+	if dir != "" {
+		metadata += ",dir " + base64.StdEncoding.EncodeToString([]byte(dir))
+	}
+	create.header.Set("Upload-Metadata", metadata)
+	resp, err := c.do(ctx, create)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("client: tus upload created without a Location")
+	}
+	// Location 是服务器上的绝对路径，通过注册中心转发时需要加上节点的前缀
+	uploadPath := apiPath("tus", path.Base(location))
+
+	var offset int64
+	if progress != nil {
+		progress(0, size)
+	}
+	for failures := 0; offset < size; {
+		n := min(size-offset, tusChunkSize)
+		patch := newRequest(http.MethodPatch, uploadPath)
+		patch.header.Set("Tus-Resumable", tusVersion)
+		patch.header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		patch.contentType = "application/offset+octet-stream"
+		start := offset
+		patch.body = func() (io.Reader, error) {
+			var r io.Reader = io.NewSectionReader(file, start, n)
+			if progress != nil {
+				r = &progressReader{r: r, done: start, total: size, progress: progress}
+			}
+			return r, nil
+		}
+
+		resp, err := c.send(ctx, patch)
+		if err == nil && resp.StatusCode == http.StatusNoContent {
+			resp.Body.Close()
+			offset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("client: invalid Upload-Offset in response: %w", err)
+			}
+			failures = 0
+			continue
+		}
+		if err == nil {
+			err = readError(resp)
+			// 409 是 offset 不一致，423 是上一个请求还没有结束，查询 offset 后继续
+			code := StatusCode(err)
+			if code != http.StatusConflict && code != http.StatusLocked && !retryStatus(code) {
+				return "", err
+			}
+		} else if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		if failures >= c.MaxRetries {
+			return "", err
+		}
+		if err := c.wait(ctx, failures, 0); err != nil {
+			return "", err
+		}
+		failures++
+		if offset, err = c.tusOffset(ctx, uploadPath); err != nil {
+			return "", err
+		}
+	}
+	return path.Join(dir, name), nil
+}
+
+// 查询服务器已经收到的字节数
+func (c *Client) tusOffset(ctx context.Context, uploadPath string) (int64, error) {
+	req := newRequest(http.MethodHead, uploadPath)
+	req.header.Set("Tus-Resumable", tusVersion)
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ListImages 返回所有 docker image 的名称
+func (c *Client) ListImages(ctx context.Context) ([]string, error) {
+	var images []string
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("images")), &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// InspectImage 返回一个 docker image 的详细信息，name 可以包含仓库，例如 library/nginx:latest
+func (c *Client) InspectImage(ctx context.Context, name string) (*ImageDetails, error) {
+	// 服务器返回只有一个元素的数组
+	var details []ImageDetails
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("images", name)), &details); err != nil {
+		return nil, err
+	}
+	if len(details) == 0 {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: "Image not found: " + name}
+	}
+	return &details[0], nil
+}
+
+// DeleteImage 删除一个 docker image
+func (c *Client) DeleteImage(ctx context.Context, name string) error {
+	return c.doJSON(ctx, newRequest(http.MethodDelete, apiPath("images", name)), nil)
+}
+
+// PullImage 拉取一个 docker image，progress 不为 nil 时实时收到每一层的进度
+func (c *Client) PullImage(ctx context.Context, name string, progress func(PullProgress)) error {
+	req := newRequest(http.MethodPost, apiPath("pull", name))
+	req.retry = true // 重复拉取不会有副作用
+	if progress == nil {
+		return c.doJSON(ctx, req, nil)
+	}
+
+	req.query.Set("stream", "ndjson")
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 每一行是一个 PullProgress，最后一行是结果，拉取失败时带有 error
+	var pullErr string
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var p PullProgress
+		if err := decoder.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if p.Error != "" {
+			pullErr = p.Error
+		}
+		progress(p)
+	}
+	if pullErr != "" {
+		return errors.New("client: pull " + name + ": " + pullErr)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Nodes 返回注册中心中的所有节点，服务器需要以注册中心模式运行
+func (c *Client) Nodes(ctx context.Context) ([]Node, error) {
+	var nodes []Node
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("nodes")), &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// GetNode 返回注册中心中的一个节点
+func (c *Client) GetNode(ctx context.Context, key string) (*Node, error) {
+	var node Node
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("nodes", key)), &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// OnNode 返回通过注册中心转发到一个节点的客户端，使用相同的 HTTPClient、Token 和重试设置
+func (c *Client) OnNode(key string) *Client {
+	node := *c
+	base := *c.base
+	base.Path = c.base.Path + apiPath("nodes", key)
+	base.RawPath = ""
+	node.setBase(&base)
+	return &node
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ListTerminals 返回所有终端会话
+func (c *Client) ListTerminals(ctx context.Context) ([]SessionInfo, error) {
+	var sessions []SessionInfo
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("terminals")), &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// GetTerminal 返回一个终端会话
+func (c *Client) GetTerminal(ctx context.Context, id string) (*SessionInfo, error) {
+	var session SessionInfo
+	if err := c.doJSON(ctx, newRequest(http.MethodGet, apiPath("terminals", id)), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// KillTerminal 结束一个终端会话，连接的客户端会收到 exit 消息
+func (c *Client) KillTerminal(ctx context.Context, id string) error {
+	return c.doJSON(ctx, newRequest(http.MethodDelete, apiPath("terminals", id)), nil)
+}
+
+// TerminalOptions 是打开终端的参数，都可以为空，默认在服务器上打开一个新的 shell
+type TerminalOptions struct {
+	Session   string // 连接到已有的会话
+	Keep      bool   // 断开连接后在服务器上保留新的会话，之后可以用 Session 重新连接
+	Container string // 在这个容器中打开终端
+	Cmd       string // 在容器中执行的命令，默认 /bin/sh
+	Name      string // 会话的名称
+	Cols      uint16 // 终端的初始大小
+	Rows      uint16
+}
+
+// 终端上收发的消息，和服务器的 api.Message 一致
+type terminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+// Terminal 是一个连接到终端会话的 WebSocket，Read 读取终端的输出，Write 发送键盘输入
+// 使用 TerminalOptions.Keep 打开或者重新连接的会话在关闭连接后保留，使用 KillTerminal 结束会话
+type Terminal struct {
+	ID string // 会话ID，可以用 TerminalOptions.Session 重新连接
+
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	pending []byte // 还没有被 Read 读取的输出
+	exit    string
+}
+
+// OpenTerminal 打开一个终端，opts 可以为 nil
+func (c *Client) OpenTerminal(ctx context.Context, opts *TerminalOptions) (*Terminal, error) {
+	if opts == nil {
+		opts = &TerminalOptions{}
+	}
+	query := url.Values{}
+	for key, value := range map[string]string{"session": opts.Session, "container": opts.Container, "cmd": opts.Cmd, "name": opts.Name} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if opts.Keep {
+		query.Set("keep", "1")
+	}
+	if opts.Cols > 0 && opts.Rows > 0 {
+		query.Set("cols", strconv.Itoa(int(opts.Cols)))
+		query.Set("rows", strconv.Itoa(int(opts.Rows)))
+	}
+	wsURL, err := url.Parse(c.url(apiPath("ws"), query))
+	if err != nil {
+		return nil, err
+	}
+	if wsURL.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	} else {
+		wsURL.Scheme = "ws"
+	}
+
+	dialer := *websocket.DefaultDialer
+	if transport, ok := c.HTTPClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+		dialer.Proxy = transport.Proxy
+	}
+	header := http.Header{}
+	c.authorize(header)
+	conn, resp, err := dialer.DialContext(ctx, wsURL.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 {
+			return nil, readError(resp)
+		}
+		return nil, err
+	}
+
+	// 服务器先发送服务信息，连接到会话后发送会话ID
+	t := &Terminal{conn: conn}
+	for t.ID == "" {
+		var msg terminalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		switch msg.Type {
+		case "session":
+			t.ID = msg.Data
+		case "output":
+			t.pending = append(t.pending, msg.Data...)
+		case "error":
+			conn.Close()
+			return nil, errors.New("client: " + msg.Data)
+		}
+	}
+	return t, nil
+}
+
+// Read 读取终端的输出，会话结束时返回 io.EOF
+func (t *Terminal) Read(p []byte) (int, error) {
+	for len(t.pending) == 0 {
+		if t.exit != "" {
+			return 0, io.EOF
+		}
+		var msg terminalMessage
+		if err := t.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		switch msg.Type {
+		case "output":
+			t.pending = append(t.pending, msg.Data...)
+		case "exit":
+			t.exit = msg.Data
+			if t.exit == "" {
+				t.exit = "exited"
+			}
+		case "error":
+			return 0, errors.New("client: " + msg.Data)
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// Write 把 p 作为键盘输入发送到终端
+func (t *Terminal) Write(p []byte) (int, error) {
+	if err := t.send(terminalMessage{Type: "input", Data: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize 修改终端的大小
+func (t *Terminal) Resize(cols, rows uint16) error {
+	return t.send(terminalMessage{Type: "resize", Cols: cols, Rows: rows})
+}
+
+// ExitReason 返回会话结束的原因，会话还没有结束时为空
+func (t *Terminal) ExitReason() string { return t.exit }
+
+// Close 断开连接，会话继续运行直到空闲超时
+func (t *Terminal) Close() error {
+	t.writeMu.Lock()
+	t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	t.writeMu.Unlock()
+	return t.conn.Close()
+}
+
+// WebSocket 不允许同时写入
+func (t *Terminal) send(msg terminalMessage) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.conn.WriteJSON(msg)
+}
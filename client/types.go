@@ -0,0 +1,168 @@
+package client
+
+// 接口返回的数据，字段和服务器的 JSON 一致
+
+// FileEntry 是文件列表中一个文件的详细信息
+type FileEntry struct {
+	Name      string `json:"Name"`
+	Path      string `json:"Path"` // 相对于 uploads 或 results 的路径
+	Size      int64  `json:"Size"`
+	SizeHuman string `json:"SizeHuman"`
+	ModTime   string `json:"ModTime"`
+	IsDir     bool   `json:"IsDir"`
+	MimeType  string `json:"MimeType"`
+	Sha256    string `json:"Sha256,omitempty"` // 只有 ListOptions.Digest 时返回
+}
+
+// BuildInfo 是一个构建任务
+type BuildInfo struct {
+	Id       string `json:"Id"`
+	File     string `json:"File"`
+	Image    string `json:"Image"`
+	Status   string `json:"Status"` // queued, running, succeeded, failed 或 canceled
+	Error    string `json:"Error,omitempty"`
+	Created  string `json:"Created"`
+	Started  string `json:"Started,omitempty"`
+	Finished string `json:"Finished,omitempty"`
+}
+
+// 构建任务的状态
+const (
+	BuildQueued    = "queued"
+	BuildRunning   = "running"
+	BuildSucceeded = "succeeded"
+	BuildFailed    = "failed"
+	BuildCanceled  = "canceled"
+)
+
+// Done 判断构建是否已经结束
+func (b *BuildInfo) Done() bool {
+	return b.Status == BuildSucceeded || b.Status == BuildFailed || b.Status == BuildCanceled
+}
+
+// ImageDetails 是一个 docker image 的详细信息
+type ImageDetails struct {
+	WorkingDir     string   `json:"WorkingDir"`
+	Entrypoint     []string `json:"Entrypoint"`
+	Cmd            []string `json:"Cmd"`
+	Id             string   `json:"Id"`
+	Created        string   `json:"Created"`
+	Size           string   `json:"Size"`
+	Architecture   string   `json:"Architecture"`
+	RepositoryTags []string `json:"RepositoryTags"`
+	Os             string   `json:"Os"`
+	DockerVersion  string   `json:"DockerVersion"`
+}
+
+// PullProgress 是拉取镜像时一层的进度
+type PullProgress struct {
+	Id      string `json:"id,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ContainerPort 是容器的一个端口映射
+type ContainerPort struct {
+	IP          string `json:"IP,omitempty"`
+	PrivatePort uint16 `json:"PrivatePort"`
+	PublicPort  uint16 `json:"PublicPort,omitempty"`
+	Type        string `json:"Type"`
+}
+
+// ContainerSummary 是一个容器的信息
+type ContainerSummary struct {
+	Id      string            `json:"Id"`
+	Name    string            `json:"Name"`
+	Image   string            `json:"Image"`
+	ImageId string            `json:"ImageId"`
+	Command string            `json:"Command"`
+	State   string            `json:"State"`
+	Status  string            `json:"Status"`
+	Created string            `json:"Created"`
+	Ports   []ContainerPort   `json:"Ports"`
+	Labels  map[string]string `json:"Labels"`
+}
+
+// ContainerCreateRequest 是创建容器的参数
+type ContainerCreateRequest struct {
+	Image  string            `json:"image"`
+	Name   string            `json:"name,omitempty"`
+	Cmd    []string          `json:"cmd,omitempty"`
+	Env    []string          `json:"env,omitempty"`
+	Ports  []string          `json:"ports,omitempty"` // 和 docker run -p 相同的格式, 例如 "8080:80/tcp"
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// JobRequest 是运行一个任务的参数
+type JobRequest struct {
+	Image     string   `json:"image"`
+	Files     []string `json:"fileNames"`           // uploads 中的文件名，可以包含子文件夹
+	Cmd       []string `json:"cmd,omitempty"`       // 覆盖 image 默认的命令
+	Env       []string `json:"env,omitempty"`       // 额外的环境变量
+	InputDir  string   `json:"inputDir,omitempty"`  // 容器内的输入目录，默认 /input
+	OutputDir string   `json:"outputDir,omitempty"` // 容器内的输出目录，默认 /output
+}
+
+// JobResult 是任务完成后的结果
+type JobResult struct {
+	ContainerId string   `json:"ContainerId"`
+	Image       string   `json:"Image"`
+	Result      string   `json:"Result"` // results 中的结果文件夹名称
+	ExitCode    int64    `json:"ExitCode"`
+	Files       []string `json:"Files"`
+	Logs        string   `json:"Logs"`
+	Duration    string   `json:"Duration"`
+}
+
+// SessionInfo 是一个终端会话
+type SessionInfo struct {
+	Id         string `json:"Id"`
+	Name       string `json:"Name"`
+	Command    string `json:"Command"`
+	Container  string `json:"Container,omitempty"` // 在容器中执行时是容器ID
+	Owner      string `json:"Owner,omitempty"`     // 创建会话的用户
+	Created    string `json:"Created"`
+	LastActive string `json:"LastActive"`
+	Clients    int    `json:"Clients"`
+	Cols       uint16 `json:"Cols"`
+	Rows       uint16 `json:"Rows"`
+}
+
+// HeartbeatConfig 是心跳的配置，时间是 "60s" 这样的字符串
+type HeartbeatConfig struct {
+	Interval    string `json:"interval"`
+	Timeout     string `json:"timeout"`
+	MaxRetries  int    `json:"maxRetries"`
+	BackoffBase string `json:"backoffBase"`
+	BackoffMax  string `json:"backoffMax"`
+}
+
+// RegistrationStatus 是服务器在注册中心的注册状态
+type RegistrationStatus struct {
+	State               string          `json:"state"`
+	ID                  string          `json:"id"`
+	URL                 string          `json:"url"`
+	CentralServer       string          `json:"centralServer"`
+	RegistryInstance    string          `json:"registryInstance,omitempty"`
+	Registrations       int             `json:"registrations"`
+	LastRegistered      string          `json:"lastRegistered,omitempty"`
+	LastHeartbeat       string          `json:"lastHeartbeat,omitempty"`
+	NextHeartbeat       string          `json:"nextHeartbeat,omitempty"`
+	ConsecutiveFailures int             `json:"consecutiveFailures"`
+	LastError           string          `json:"lastError,omitempty"`
+	Heartbeat           HeartbeatConfig `json:"heartbeat"`
+}
+
+// Node 是注册中心中的一个节点
+type Node struct {
+	Key        string                 `json:"key"` // 用在 OnNode 和 URL 中
+	ID         string                 `json:"_id"`
+	URL        string                 `json:"url"`
+	PublicURL  string                 `json:"publicUrl"`
+	HostInfo   map[string]interface{} `json:"hostInfo"`
+	Registered string                 `json:"registered"`
+	LastSeen   string                 `json:"lastSeen"`
+	ExpiresAt  string                 `json:"expiresAt"`
+}
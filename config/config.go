@@ -0,0 +1,470 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置的优先级: 命令行参数 > 环境变量 > 配置文件 > 默认值
+// 配置文件的格式见 config.example.yaml，默认读取 ./config.yaml，可以用 -config 或者环境变量 UPC_CONFIG 指定
+// 每个环境变量都有同名的命令行参数，见 Flags
+
+// 默认的配置文件
+const DefaultPath = "./config.yaml"
+
+// Config 是服务的所有配置
+type Config struct {
+	Server       Server       `yaml:"server"`
+	Storage      Storage      `yaml:"storage"`
+	Limits       Limits       `yaml:"limits"`
+	Registration Registration `yaml:"registration"`
+	Registry     Registry     `yaml:"registry"`
+	Builder      Builder      `yaml:"builder"`
+	Auth         Auth         `yaml:"auth"`
+	Log          Log          `yaml:"log"`
+}
+
+// Server 是HTTP服务的配置
+type Server struct {
+	Listen    string `yaml:"listen"`    // 监听地址，例如 ":4000"、"127.0.0.1:4000"
+	PublicURL string `yaml:"publicUrl"` // 注册时使用的地址，端口会被替换为监听的端口，没有端口时原样使用，启用TLS时使用 https
+	TLS       TLS    `yaml:"tls"`
+}
+
+// TLS 是HTTPS的配置
+type TLS struct {
+	Enabled       bool   `yaml:"enabled"`       // 使用HTTPS
+	CertFile      string `yaml:"certFile"`      // 证书文件，和 keyFile 都为空时使用自动生成的自签名证书
+	KeyFile       string `yaml:"keyFile"`       // 私钥文件
+	SelfSignedDir string `yaml:"selfSignedDir"` // 自签名证书保存的目录，第一次启动时生成
+	ClientAuth    string `yaml:"clientAuth"`    // 客户端证书 (mTLS): none、optional 或 require
+	ClientCAFile  string `yaml:"clientCAFile"`  // 验证客户端证书的CA
+	// 请求注册中心和转发到节点时使用，本节点的证书同时作为客户端证书
+	CAFile             string `yaml:"caFile"`             // 额外信任的CA，例如其它节点的自签名证书
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"` // 不验证对方的证书，只用于测试
+}
+
+// 客户端证书的验证方式
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Scheme 返回 http 或者 https
+func (s *Server) Scheme() string {
+	if s.TLS.Enabled {
+		return "https"
+	}
+	return "http"
+}
+
+// Storage 是数据目录
+type Storage struct {
+	Uploads string `yaml:"uploads"` // 上传的文件
+	Results string `yaml:"results"` // 处理结果
+	Jobs    string `yaml:"jobs"`    // 处理任务的临时输出
+	Tus     string `yaml:"tus"`     // 未完成的断点续传上传
+}
+
+// Limits 是资源限制
+type Limits struct {
+	FormMemory          ByteSize `yaml:"formMemory"`          // 上传表单在内存中缓冲的大小，超过的部分写入临时文件
+	MaxUploadSize       ByteSize `yaml:"maxUploadSize"`       // 单个上传请求或断点续传文件的最大大小，0 表示不限制
+	MaxConcurrentBuilds int      `yaml:"maxConcurrentBuilds"` // 同时运行的构建数量
+	MaxFinishedBuilds   int      `yaml:"maxFinishedBuilds"`   // 内存中保留的已结束构建数量
+	TerminalIdleTimeout Duration `yaml:"terminalIdleTimeout"` // 终端会话没有客户端连接后保留的时间
+	MaxTerminalSessions int      `yaml:"maxTerminalSessions"` // 同时存在的终端会话数量
+	UploadExpiry        Duration `yaml:"uploadExpiry"`        // 未完成的断点续传上传在最后一次写入后保留的时间
+}
+
+// Registration 是注册到注册中心的配置
+type Registration struct {
+	Enabled       bool      `yaml:"enabled"`       // 是否注册到注册中心
+	CentralServer string    `yaml:"centralServer"` // 注册中心的地址，默认 http://localhost:8000，内置注册中心模式下默认注册到自己
+	Token         string    `yaml:"token"`         // 注册中心的令牌，对应注册中心的 registration.token
+	Heartbeat     Heartbeat `yaml:"heartbeat"`
+}
+
+// Heartbeat 是心跳和重试的配置
+type Heartbeat struct {
+	Interval    Duration `yaml:"interval"`    // 正常的心跳间隔
+	Timeout     Duration `yaml:"timeout"`     // 每次请求注册中心的超时时间
+	MaxRetries  int      `yaml:"maxRetries"`  // 连续失败多少次以内按退避时间重试
+	BackoffBase Duration `yaml:"backoffBase"` // 第一次重试前等待的时间，之后每次翻倍
+	BackoffMax  Duration `yaml:"backoffMax"`  // 重试前最多等待的时间
+}
+
+// Registry 是内置注册中心的配置
+type Registry struct {
+	Enabled bool     `yaml:"enabled"` // 同时作为注册中心
+	NodeTTL Duration `yaml:"nodeTTL"` // 超过这个时间没有心跳的节点会被移除
+}
+
+// Builder 是构建镜像的配置
+type Builder struct {
+	Image string `yaml:"image"` // pack build 使用的 builder
+	Pack  string `yaml:"pack"`  // pack 命令的路径
+}
+
+// Auth 是认证的配置，没有配置 apiKeys 和 jwtSecret 时不做认证
+type Auth struct {
+	APIKeys        []APIKey `yaml:"apiKeys"`
+	JWTSecret      string   `yaml:"jwtSecret"`      // JWT 的 HMAC 签名密钥
+	AllowedOrigins []string `yaml:"allowedOrigins"` // 允许连接 /ws 的来源，默认只允许同源
+}
+
+// APIKey 是一个静态的 API key
+type APIKey struct {
+	Key  string `yaml:"key"`
+	Role string `yaml:"role"` // viewer、operator 或 admin
+}
+
+// Log 是日志的配置
+type Log struct {
+	Format string `yaml:"format"` // text 或 json
+	Level  string `yaml:"level"`  // debug、info、warn 或 error
+}
+
+// 日志的格式
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Default 返回默认配置，和之前硬编码的值一致
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Listen:    ":4000",
+			PublicURL: "http://localhost:4000",
+			TLS: TLS{
+				SelfSignedDir: "./tls",
+				ClientAuth:    ClientAuthNone,
+			},
+		},
+		Storage: Storage{
+			Uploads: "./uploads",
+			Results: "./results",
+			Jobs:    "./jobs",
+			Tus:     "./tus",
+		},
+		Limits: Limits{
+			FormMemory:          100 << 20,
+			MaxConcurrentBuilds: 2,
+			MaxFinishedBuilds:   100,
+			TerminalIdleTimeout: Duration(10 * time.Minute),
+			MaxTerminalSessions: 20,
+			UploadExpiry:        Duration(24 * time.Hour),
+		},
+		Registration: Registration{
+			Enabled: true,
+			Heartbeat: Heartbeat{
+				Interval:    Duration(60 * time.Second),
+				Timeout:     Duration(10 * time.Second),
+				MaxRetries:  5,
+				BackoffBase: Duration(time.Second),
+				BackoffMax:  Duration(60 * time.Second),
+			},
+		},
+		Registry: Registry{
+			NodeTTL: Duration(3 * time.Minute),
+		},
+		Builder: Builder{
+			Image: "paketobuildpacks/builder-jammy-base",
+			Pack:  "pack",
+		},
+		Log: Log{
+			Format: LogFormatText,
+			Level:  "info",
+		},
+	}
+}
+
+// Load 依次读取默认值、配置文件、环境变量和命令行参数
+// path 为空时使用 UPC_CONFIG 或者 ./config.yaml，默认的配置文件不存在时忽略
+// flags 是命令行中指定了的参数，最后应用
+func Load(path string, flags ...func(c *Config) error) (*Config, error) {
+	cfg := Default()
+
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("UPC_CONFIG")
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultPath
+	}
+	if err := cfg.loadFile(path); err != nil {
+		if explicit || !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	for _, flag := range flags {
+		if err := flag(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// 启用TLS时注册 https 的地址
+	if cfg.Server.TLS.Enabled && strings.HasPrefix(cfg.Server.PublicURL, "http://") {
+		cfg.Server.PublicURL = "https://" + strings.TrimPrefix(cfg.Server.PublicURL, "http://")
+	}
+
+	// 没有指定注册中心时，内置注册中心模式下注册到自己
+	if cfg.Registration.CentralServer == "" {
+		cfg.Registration.CentralServer = "http://localhost:8000"
+		if cfg.Registry.Enabled {
+			cfg.Registration.CentralServer = cfg.Server.Scheme() + "://localhost:" + cfg.Port()
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 读取配置文件，文件中没有的字段保留原来的值
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// 空文件返回 io.EOF
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// 环境变量和对应的配置
+var envVars = []struct {
+	name string
+	set  func(c *Config, value string) error
+}{
+	{"LISTEN_ADDR", setString(func(c *Config) *string { return &c.Server.Listen })},
+	{"API_PORT", func(c *Config, v string) error { return c.SetPort(v) }}, // 只修改端口
+	{"API_URL", setString(func(c *Config) *string { return &c.Server.PublicURL })},
+	{"TLS_ENABLED", setBool(func(c *Config) *bool { return &c.Server.TLS.Enabled })},
+	{"TLS_CERT_FILE", setString(func(c *Config) *string { return &c.Server.TLS.CertFile })},
+	{"TLS_KEY_FILE", setString(func(c *Config) *string { return &c.Server.TLS.KeyFile })},
+	{"TLS_CLIENT_AUTH", setString(func(c *Config) *string { return &c.Server.TLS.ClientAuth })},
+	{"TLS_CLIENT_CA_FILE", setString(func(c *Config) *string { return &c.Server.TLS.ClientCAFile })},
+	{"TLS_CA_FILE", setString(func(c *Config) *string { return &c.Server.TLS.CAFile })},
+	{"UPLOADS_DIR", setString(func(c *Config) *string { return &c.Storage.Uploads })},
+	{"RESULTS_DIR", setString(func(c *Config) *string { return &c.Storage.Results })},
+	{"JOBS_DIR", setString(func(c *Config) *string { return &c.Storage.Jobs })},
+	{"TUS_DIR", setString(func(c *Config) *string { return &c.Storage.Tus })},
+	{"MAX_UPLOAD_SIZE", func(c *Config, v string) error { return c.Limits.MaxUploadSize.Set(v) }},
+	{"MAX_CONCURRENT_BUILDS", setInt(func(c *Config) *int { return &c.Limits.MaxConcurrentBuilds })},
+	{"TERMINAL_IDLE_TIMEOUT", setDuration(func(c *Config) *Duration { return &c.Limits.TerminalIdleTimeout })},
+	{"MAX_TERMINAL_SESSIONS", setInt(func(c *Config) *int { return &c.Limits.MaxTerminalSessions })},
+	{"UPLOAD_EXPIRY", setDuration(func(c *Config) *Duration { return &c.Limits.UploadExpiry })},
+	{"REGISTER", setBool(func(c *Config) *bool { return &c.Registration.Enabled })},
+	{"CENTRAL_SERVER", setString(func(c *Config) *string { return &c.Registration.CentralServer })},
+	{"REGISTRY_TOKEN", setString(func(c *Config) *string { return &c.Registration.Token })},
+	{"HEARTBEAT_INTERVAL", setDuration(func(c *Config) *Duration { return &c.Registration.Heartbeat.Interval })},
+	{"HEARTBEAT_TIMEOUT", setDuration(func(c *Config) *Duration { return &c.Registration.Heartbeat.Timeout })},
+	{"HEARTBEAT_MAX_RETRIES", setInt(func(c *Config) *int { return &c.Registration.Heartbeat.MaxRetries })},
+	{"HEARTBEAT_BACKOFF_BASE", setDuration(func(c *Config) *Duration { return &c.Registration.Heartbeat.BackoffBase })},
+	{"HEARTBEAT_BACKOFF_MAX", setDuration(func(c *Config) *Duration { return &c.Registration.Heartbeat.BackoffMax })},
+	{"REGISTRY", setBool(func(c *Config) *bool { return &c.Registry.Enabled })},
+	{"REGISTRY_NODE_TTL", setDuration(func(c *Config) *Duration { return &c.Registry.NodeTTL })},
+	{"BUILDER_IMAGE", setString(func(c *Config) *string { return &c.Builder.Image })},
+	{"PACK_PATH", setString(func(c *Config) *string { return &c.Builder.Pack })},
+	{"API_KEYS", func(c *Config, v string) error { return c.Auth.SetAPIKeys(v) }},
+	{"JWT_SECRET", setString(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"ALLOWED_ORIGINS", func(c *Config, v string) error { c.Auth.AllowedOrigins = SplitList(v); return nil }},
+	{"LOG_FORMAT", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"LOG_LEVEL", setString(func(c *Config) *string { return &c.Log.Level })},
+}
+
+// 读取环境变量
+func (c *Config) loadEnv() error {
+	for _, env := range envVars {
+		value, ok := os.LookupEnv(env.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := env.set(c, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", env.name, err)
+		}
+	}
+	return nil
+}
+
+func setString(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error { *field(c) = v; return nil }
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error { return field(c).Set(v) }
+}
+
+// SetPort 只修改监听地址中的端口
+func (c *Config) SetPort(port string) error {
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	host, _, err := net.SplitHostPort(c.Server.Listen)
+	if err != nil {
+		host = ""
+	}
+	c.Server.Listen = net.JoinHostPort(host, port)
+	return nil
+}
+
+// Port 返回监听地址中的端口
+func (c *Config) Port() string {
+	_, port, err := net.SplitHostPort(c.Server.Listen)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// SetAPIKeys 解析 "key1:admin,key2:viewer" 格式的 API key 列表
+func (a *Auth) SetAPIKeys(value string) error {
+	a.APIKeys = nil
+	for _, item := range SplitList(value) {
+		key, role, ok := strings.Cut(item, ":")
+		if !ok || key == "" {
+			return fmt.Errorf("expected key:role, got %q", item)
+		}
+		a.APIKeys = append(a.APIKeys, APIKey{Key: key, Role: role})
+	}
+	return nil
+}
+
+// SplitList 把逗号分隔的字符串转换为数组
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate 检查配置是否合法
+func (c *Config) Validate() error {
+	if c.Port() == "" {
+		return fmt.Errorf("server.listen must be host:port, got %q", c.Server.Listen)
+	}
+	tls := c.Server.TLS
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		return fmt.Errorf("server.tls.certFile and server.tls.keyFile must be set together")
+	}
+	switch tls.ClientAuth {
+	case ClientAuthNone:
+	case ClientAuthOptional, ClientAuthRequire:
+		if tls.ClientCAFile == "" {
+			return fmt.Errorf("server.tls.clientCAFile is required when clientAuth is %s", tls.ClientAuth)
+		}
+		if !tls.Enabled {
+			return fmt.Errorf("server.tls.clientAuth needs server.tls.enabled")
+		}
+	default:
+		return fmt.Errorf("server.tls.clientAuth must be none, optional or require, got %q", tls.ClientAuth)
+	}
+	if c.Storage.Uploads == "" || c.Storage.Results == "" || c.Storage.Jobs == "" || c.Storage.Tus == "" {
+		return fmt.Errorf("storage directories must not be empty")
+	}
+	if c.Limits.MaxConcurrentBuilds < 1 {
+		return fmt.Errorf("limits.maxConcurrentBuilds must be at least 1")
+	}
+	if c.Limits.MaxTerminalSessions < 1 {
+		return fmt.Errorf("limits.maxTerminalSessions must be at least 1")
+	}
+	if c.Limits.UploadExpiry <= 0 {
+		return fmt.Errorf("limits.uploadExpiry must be positive")
+	}
+	if c.Limits.MaxFinishedBuilds < 0 || c.Limits.MaxUploadSize < 0 || c.Limits.FormMemory < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	heartbeat := c.Registration.Heartbeat
+	if heartbeat.Interval <= 0 || heartbeat.Timeout <= 0 || heartbeat.BackoffBase <= 0 || heartbeat.BackoffMax <= 0 {
+		return fmt.Errorf("registration.heartbeat durations must be positive")
+	}
+	if heartbeat.MaxRetries < 0 {
+		return fmt.Errorf("registration.heartbeat.maxRetries must not be negative")
+	}
+	if c.Registry.NodeTTL <= 0 {
+		return fmt.Errorf("registry.nodeTTL must be positive")
+	}
+	if c.Registry.Enabled && c.Registration.Token == "" {
+		// 没有令牌时任何人都可以注册节点，让注册中心转发到任意地址
+		return fmt.Errorf("registry.enabled needs registration.token")
+	}
+	if c.Log.Format != LogFormatText && c.Log.Format != LogFormatJSON {
+		return fmt.Errorf("log.format must be text or json, got %q", c.Log.Format)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return fmt.Errorf("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	return nil
+}
+
+// 隐藏密钥后输出
+const redacted = "********"
+
+// YAML 返回配置的 YAML 格式，密钥会被隐藏
+func (c *Config) YAML() ([]byte, error) {
+	out := *c
+	out.Auth.APIKeys = make([]APIKey, len(c.Auth.APIKeys))
+	for i, key := range c.Auth.APIKeys {
+		out.Auth.APIKeys[i] = APIKey{Key: redacted, Role: key.Role}
+	}
+	if out.Auth.JWTSecret != "" {
+		out.Auth.JWTSecret = redacted
+	}
+	if out.Registration.Token != "" {
+		out.Registration.Token = redacted
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// 命令行参数: 每个环境变量都有一个对应的参数，名称是小写加连字符，例如 UPLOADS_DIR -> -uploads-dir
+// 命令行中指定了的参数在环境变量之后应用
+
+// 布尔类型的环境变量，对应的参数可以不带值，例如 -tls-enabled
+var boolEnvVars = map[string]bool{"TLS_ENABLED": true, "REGISTER": true, "REGISTRY": true}
+
+// FlagName 返回环境变量对应的命令行参数名称
+func FlagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// 一个环境变量对应的命令行参数，记录命令行中的值
+type envFlag struct {
+	env     string
+	set     func(c *Config, value string) error
+	boolean bool
+	value   *string // 命令行中没有指定时为 nil
+}
+
+func (f *envFlag) String() string {
+	if f == nil || f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f *envFlag) Set(value string) error {
+	f.value = &value
+	return nil
+}
+
+func (f *envFlag) IsBoolFlag() bool { return f.boolean }
+
+// Flags 为每个环境变量在 fs 中注册一个命令行参数，fs 中已经存在的参数不会重复注册
+// 解析命令行之后调用返回的函数，得到命令行中指定了的参数，传给 Load
+func Flags(fs *flag.FlagSet) func() []func(c *Config) error {
+	var bound []*envFlag
+	for _, env := range envVars {
+		name := FlagName(env.name)
+		if fs.Lookup(name) != nil {
+			continue
+		}
+		f := &envFlag{env: env.name, set: env.set, boolean: boolEnvVars[env.name]}
+		fs.Var(f, name, "overrides $"+env.name)
+		bound = append(bound, f)
+	}
+
+	return func() []func(c *Config) error {
+		var flags []func(c *Config) error
+		for _, f := range bound {
+			if f.value == nil {
+				continue
+			}
+			flags = append(flags, func(c *Config) error {
+				if err := f.set(c, *f.value); err != nil {
+					return fmt.Errorf("invalid -%s: %w", FlagName(f.env), err)
+				}
+				return nil
+			})
+		}
+		return flags
+	}
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"UPC-GO/config"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// 使用 log/slog 输出结构化日志，格式和级别在配置的 log 中设置
+// 处理请求时使用 slog.InfoContext(r.Context(), ...)，日志会自动带上请求ID
+
+// 请求ID的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+// 请求ID在日志中的字段名
+const RequestIDKey = "request_id"
+
+// Setup 根据配置设置默认的 logger，标准库 log 的输出也会转到 slog
+func Setup(cfg config.Log) error {
+	return SetupWriter(os.Stderr, cfg)
+}
+
+// SetupWriter 和 Setup 一样，但是输出到 w
+func SetupWriter(w io.Writer, cfg config.Log) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if cfg.Format == config.LogFormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// contextHandler 把 context 中的请求ID加到每一条日志中
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ************************************************  请求ID  ************************************************
+
+type requestIDKey struct{}
+
+// WithRequestID 返回带有请求ID的 context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 返回 context 中的请求ID，没有时返回空字符串
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID 生成一个随机的请求ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 客户端或者注册中心传来的请求ID，太长或者包含特殊字符时重新生成
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware 给每个请求分配一个请求ID，放到 context 和 X-Request-ID 响应头中
+// 请求中已经有 X-Request-ID 时沿用，这样经过注册中心转发的请求在两边的日志中是同一个ID
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		r.Header.Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
@@ -2,110 +2,167 @@ package main
 
 import (
 	"UPC-GO/api"
+	"UPC-GO/cli"
+	"UPC-GO/config"
+	"UPC-GO/logging"
+	"UPC-GO/metrics"
 	"UPC-GO/register"
+	"UPC-GO/registry"
+	"UPC-GO/tlsconfig"
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 )
 
 func main() {
-	// 定义一个命令行参数，用于指定端口
+	// upc files、upc build 等子命令连接远程节点，见 cli 包
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
+	// 命令行参数，优先级高于环境变量和配置文件
+	configPath := flag.String("config", "", "config file (default $UPC_CONFIG or ./config.yaml)")
 	inputPort := flag.String("p", "4000", "port to listen on")
+	registryMode := flag.Bool("registry", false, "also act as the central registry server for other nodes")
+	printConfig := flag.Bool("print-config", false, "print the effective config and exit")
+	// 其它配置都可以用和环境变量同名的参数修改，例如 -uploads-dir 对应 UPLOADS_DIR
+	configFlags := config.Flags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\nRun '%s help' for the client commands.\n"+
+			"Flags override environment variables, which override the config file.\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	// 设置默认端口
-	port := "4000"
-
-	// 如果命令行参数 -p 被指定，则使用命令行参数
-	if *inputPort != "4000" {
-		port = *inputPort
-	} else {
-		// 否则检查环境变量 API_PORT
-		envPort := os.Getenv("API_PORT")
-		if envPort != "" {
-			port = envPort
+	// 只应用命令行中指定了的参数
+	flags := configFlags()
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "p":
+			flags = append(flags, func(c *config.Config) error { return c.SetPort(*inputPort) })
+		case "registry":
+			flags = append(flags, func(c *config.Config) error { c.Registry.Enabled = *registryMode; return nil })
 		}
+	})
+
+	cfg, err := config.Load(*configPath, flags...)
+	if err != nil {
+		fatal("Invalid config", err)
+	}
+	if *printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			fatal("Failed to print config", err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+	if err := logging.Setup(cfg.Log); err != nil {
+		fatal("Invalid log config", err)
 	}
+	if err := api.Configure(cfg); err != nil {
+		fatal("Invalid config", err)
+	}
+	register.Configure(cfg)
 
-	addr := ":" + port
-	log.Println("Starting server on : " + port)
+	// HTTPS，请求注册中心和其它节点时也使用本节点的证书
+	var serverTLS *tls.Config
+	if cfg.Server.TLS.Enabled {
+		if serverTLS, err = tlsconfig.Server(cfg); err != nil {
+			fatal("Invalid TLS config", err)
+		}
+	}
+	clientTLS, err := tlsconfig.Client(cfg)
+	if err != nil {
+		fatal("Invalid TLS config", err)
+	}
+	register.TLSClientConfig = clientTLS
+
+	port := cfg.Port()
+	addr := cfg.Server.Listen
+	slog.Info("Starting server", "addr", addr, "scheme", cfg.Server.Scheme())
+
+	// 内置注册中心模式
+	router := api.NewRouter()
+	if cfg.Registry.Enabled {
+		reg := registry.New(cfg.Registry.NodeTTL.D(), cfg.Registration.Token, clientTLS)
+		reg.JWTSecret = []byte(cfg.Auth.JWTSecret)
+		reg.Register(router)
+	}
 
 	// 注册服务
-	success := register.RegisterService(port)
-	if success {
-		fmt.Println("Service registered successfully")
-	} else {
-		fmt.Println("Service registration failed")
-	}
+	if cfg.Registration.Enabled {
+		if register.RegisterService(port) {
+			slog.Info("Service registered successfully", "url", register.URL)
+		} else {
+			slog.Warn("Service registration failed, retrying in the background")
+		}
 
-	// 循环发送心跳
-	// 创建一个定时器，每隔HeartbeatInterval发送一次心跳信号
-	HeartbeatInterval := 60 * time.Second
-	ticker := time.NewTicker(HeartbeatInterval)
-	defer ticker.Stop()
+		// 循环发送心跳，间隔、超时和重试策略见 register.HeartbeatConfig
+		// 注册失败或者注册中心丢失了注册信息时会自动重新注册
+		stopHeartbeat := make(chan struct{})
+		defer close(stopHeartbeat)
+		go register.RunHeartbeat(stopHeartbeat)
+	}
 
-	go func() {
-		for range ticker.C {
-			success := register.SendHeartbeat()
-			if success {
-				// 当前时间
-				timeNow := time.Now().Format("2006-01-02 15:04:05")
-				fmt.Println("Heartbeat sent successfully -- " + timeNow)
-			} else {
-				fmt.Println("Failed to send heartbeat")
-			}
-		}
-	}()
+	// 删除过期的断点续传上传
+	go api.RunTusExpiry()
 
 	// 启动服务器
-	if err := StartServer(addr); err != nil {
-		log.Fatalf("ListenAndServe: %v", err)
+	if err := StartServer(addr, router, serverTLS); err != nil {
+		fatal("ListenAndServe failed", err)
 	}
 }
 
+// 输出错误日志后退出
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 // HTTP服务器
 type Server struct {
+	router    *api.Router
+	tlsConfig *tls.Config // 不为 nil 时使用HTTPS
 }
 
 // NewServer 创建一个新的服务器实例
-func NewServer() *Server {
-	return &Server{}
+func NewServer(router *api.Router, tlsConfig *tls.Config) *Server {
+	return &Server{router: router, tlsConfig: tlsConfig}
 }
 
 // Start 启动服务器
 func (s *Server) Start(addr string) error {
+	rt := s.router
 	// 静态文件服务器 /static/ -> ./public
 	fs := http.FileServer(http.Dir("./public"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
-
-	http.HandleFunc("/ws", api.HandleWebSocket)
-
-	// 路由
-	http.HandleFunc("/", IndexHandler)
-	http.HandleFunc("/api", ConnectHandler)
-	http.HandleFunc("/api/files", api.FilesHandler)             // get /api/files 获取所有文件的列表
-	http.HandleFunc("/api/files/", api.FileProcessor)           // get /api/files/:filename 对一个文件进行操作
-	http.HandleFunc("/api/files/download", api.MultiDownloader) // get /api/files/download 下载多个文件
-	http.HandleFunc("/api/results", api.ResultsHandler)         // get /api/results 获取所有结果的列表
-	http.HandleFunc("/api/results/", api.ResultProcessor)       // get /api/results/:resultName 下载或删除一个结果
-	http.HandleFunc("/api/upload", api.UploadHandler)           // post /api/upload 上传文件
-	http.HandleFunc("/api/images", api.ImagesHandler)           // get /api/images 获取所有docker images 的列表
-	http.HandleFunc("/api/images/", api.ImageProcessor)         // get /api/images/:imageName 对一个docker image 进行操作
-	http.HandleFunc("/api/pull/", api.ImagePuller)              // post /api/pull/:imageName 拉取一个docker image
+	rt.Handle("GET /static/", http.StripPrefix("/static/", fs))
+	rt.HandleFunc("GET /{$}", IndexHandler)
+	// 接口见 api.Routes
+	api.Routes(rt)
 
 	// 创建一个 http.Server 实例
-	server := &http.Server{Addr: addr}
+	// 所有请求先分配请求ID，再经过认证和权限检查，被拒绝的请求也记录到指标中
+	handler := logging.Middleware(metrics.Instrument(rt, api.AuthMiddleware(rt)))
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: s.tlsConfig}
 
 	// 启动服务器的 Goroutine，这样我们可以在主线程中等待服务器关闭
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe: %v", err)
+		var err error
+		if s.tlsConfig != nil {
+			// 证书已经在 TLSConfig 中
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("ListenAndServe failed", err)
 		}
 	}()
 
@@ -114,9 +171,9 @@ func (s *Server) Start(addr string) error {
 	return nil
 }
 
-// 启动服务器
-func StartServer(addr string) error {
-	server := NewServer()
+// 启动服务器，tlsConfig 不为 nil 时使用HTTPS
+func StartServer(addr string, router *api.Router, tlsConfig *tls.Config) error {
+	server := NewServer(router, tlsConfig)
 	return server.Start(addr)
 }
 
@@ -125,13 +182,15 @@ func waitForShutdown(server *http.Server) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server...")
 
-	// 关闭服务器，注销服务
-	register.UnregisterService()
+	// 关闭服务器，注册过时注销服务
+	if register.Status().Registrations > 0 {
+		register.UnregisterService()
+	}
 
 	if err := server.Shutdown(context.Background()); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		fatal("Server forced to shutdown", err)
 	}
 }
 
@@ -140,9 +199,3 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 	api.Cors(w)
 	http.ServeFile(w, r, "./views/index.html")
 }
-
-// get /api 是测试这个服务器是否正常工作
-func ConnectHandler(w http.ResponseWriter, r *http.Request) {
-	api.Cors(w)
-	w.Write([]byte("Connect success!"))
-}
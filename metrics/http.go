@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTP 请求的指标，route 是路由的模式，例如 GET /api/v1/files/{name...}，避免每个文件名都成为一个序列
+var (
+	httpRequests = NewCounterVec("upc_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "status")
+	httpDuration = NewHistogramVec("upc_http_request_duration_seconds",
+		"HTTP request latency by route, method and status code.", DefBuckets, "route", "method", "status")
+	httpUploaded = NewCounterVec("upc_http_uploaded_bytes_total",
+		"Request body bytes received by route.", "route")
+	httpDownloaded = NewCounterVec("upc_http_downloaded_bytes_total",
+		"Response body bytes sent by route.", "route")
+	httpInFlight = NewGaugeVec("upc_http_requests_in_flight",
+		"HTTP requests currently being served.")
+)
+
+// Matcher 找到请求对应的路由，http.ServeMux 和 api.Router 都实现了它
+type Matcher interface {
+	Handler(r *http.Request) (h http.Handler, pattern string)
+}
+
+// Instrument 记录每个请求的数量、耗时和传输的字节数，mux 用来找到请求对应的路由
+func Instrument(mux Matcher, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		start := time.Now()
+		httpInFlight.Add(1)
+		defer httpInFlight.Add(-1)
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			// WebSocket 等被接管的连接不统计字节数
+			status := strconv.Itoa(rec.status)
+			if rec.hijacked {
+				status = "101"
+			}
+			httpRequests.Inc(route, r.Method, status)
+			httpDuration.Observe(time.Since(start).Seconds(), route, r.Method, status)
+			httpUploaded.Add(float64(body.n), route)
+			httpDownloaded.Add(float64(rec.written), route)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// 统计读取的字节数
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// 记录响应的状态码和字节数，同时保留 Flusher 和 Hijacker，流式响应和 WebSocket 需要
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		// 1xx 不是最终的状态码
+		s.wroteHeader = code >= 200
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker not supported")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		s.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap 让 http.ResponseController 可以找到原始的 ResponseWriter
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
@@ -0,0 +1,311 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 简单的 Prometheus 指标，输出 text 格式 (0.0.4)，不依赖 Prometheus 的客户端库
+// 支持带标签的 counter、gauge 和 histogram，gauge 也可以在每次抓取前通过 OnScrape 更新
+
+// 默认的请求耗时分桶，单位是秒
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 构建和拉取镜像这类长任务的耗时分桶，单位是秒
+var LongBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// 一个指标
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry 保存所有指标
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	hooks   []func()
+}
+
+// 默认的 Registry，/metrics 输出它的所有指标
+var Default = &Registry{}
+
+func (reg *Registry) register(m metric) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, existing := range reg.metrics {
+		if existing.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	reg.metrics = append(reg.metrics, m)
+}
+
+// OnScrape 注册一个在每次抓取前调用的函数，用来更新 gauge
+func (reg *Registry) OnScrape(fn func()) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.hooks = append(reg.hooks, fn)
+}
+
+// OnScrape 在默认的 Registry 上注册抓取前调用的函数
+func OnScrape(fn func()) { Default.OnScrape(fn) }
+
+// Write 按 text 格式输出所有指标
+func (reg *Registry) Write(w *bufio.Writer) {
+	reg.mu.Lock()
+	hooks := append([]func(){}, reg.hooks...)
+	metrics := append([]metric{}, reg.metrics...)
+	reg.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler 返回输出默认 Registry 的 HTTP handler
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		Default.Write(buf)
+		buf.Flush()
+	})
+}
+
+// ************************************************  标签  ************************************************
+
+// 一组标签值对应的序列
+type series[T any] struct {
+	values []string
+	data   T
+}
+
+// 带标签的指标的公共部分
+type vec[T any] struct {
+	mu     sync.Mutex
+	Name   string
+	Help   string
+	Type   string
+	labels []string
+	series map[string]*series[T]
+	init   func() T
+}
+
+func newVec[T any](name, help, typ string, labels []string, init func() T) *vec[T] {
+	return &vec[T]{Name: name, Help: help, Type: typ, labels: labels, series: make(map[string]*series[T]), init: init}
+}
+
+func (v *vec[T]) name() string { return v.Name }
+
+// 找到或者创建标签值对应的序列，调用时需要持有 v.mu
+func (v *vec[T]) get(values []string) *series[T] {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.Name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{values: append([]string(nil), values...), data: v.init()}
+		v.series[key] = s
+	}
+	return s
+}
+
+// 按标签值排序，输出稳定
+func (v *vec[T]) sorted() []*series[T] {
+	all := make([]*series[T], 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+	return all
+}
+
+func (v *vec[T]) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.Name, escapeHelp(v.Help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.Name, v.Type)
+}
+
+// 格式化标签，extra 是额外的标签，例如 histogram 的 le
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, escapeLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[i], escapeLabel(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ************************************************  Counter 和 Gauge  ************************************************
+
+// CounterVec 是只增不减的计数器
+type CounterVec struct {
+	v *vec[*float64]
+}
+
+// NewCounterVec 创建并注册一个计数器
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", labels, func() *float64 { return new(float64) })}
+	Default.register(c)
+	return c
+}
+
+// Inc 加一
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add 增加 delta，delta 不能是负数
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.v.mu.Lock()
+	*c.v.get(labelValues).data += delta
+	c.v.mu.Unlock()
+}
+
+func (c *CounterVec) name() string { return c.v.Name }
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	c.v.header(w)
+	for _, s := range c.v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.v.Name, formatLabels(c.v.labels, s.values), formatFloat(*s.data))
+	}
+}
+
+// GaugeVec 是可以任意设置的值
+type GaugeVec struct {
+	v *vec[*float64]
+}
+
+// NewGaugeVec 创建并注册一个 gauge
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec(name, help, "gauge", labels, func() *float64 { return new(float64) })}
+	Default.register(g)
+	return g
+}
+
+// Set 设置当前值
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.mu.Lock()
+	*g.v.get(labelValues).data = value
+	g.v.mu.Unlock()
+}
+
+// Add 增加 delta，可以是负数
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.v.mu.Lock()
+	*g.v.get(labelValues).data += delta
+	g.v.mu.Unlock()
+}
+
+func (g *GaugeVec) name() string { return g.v.Name }
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+	g.v.header(w)
+	for _, s := range g.v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", g.v.Name, formatLabels(g.v.labels, s.values), formatFloat(*s.data))
+	}
+}
+
+// ************************************************  Histogram  ************************************************
+
+type histogramData struct {
+	counts []uint64 // 每个桶的数量，不是累计值
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec 统计值的分布，例如请求耗时
+type HistogramVec struct {
+	v       *vec[*histogramData]
+	buckets []float64
+}
+
+// NewHistogramVec 创建并注册一个 histogram，buckets 是每个桶的上限，需要从小到大排列
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{buckets: buckets}
+	h.v = newVec(name, help, "histogram", labels, func() *histogramData {
+		return &histogramData{counts: make([]uint64, len(buckets))}
+	})
+	Default.register(h)
+	return h
+}
+
+// Observe 记录一个值
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	data := h.v.get(labelValues).data
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		data.counts[i]++
+	}
+	data.sum += value
+	data.count++
+}
+
+func (h *HistogramVec) name() string { return h.v.Name }
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	h.v.header(w)
+	for _, s := range h.v.sorted() {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.data.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.Name, formatLabels(h.v.labels, s.values, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.Name, formatLabels(h.v.labels, s.values, "le", "+Inf"), s.data.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.v.Name, formatLabels(h.v.labels, s.values), formatFloat(s.data.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.v.Name, formatLabels(h.v.labels, s.values), s.data.count)
+	}
+}
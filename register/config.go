@@ -0,0 +1,27 @@
+package register
+
+import (
+	"UPC-GO/config"
+)
+
+// Configure 使用配置文件中的注册和心跳配置，需要在 RegisterService 之前调用
+func Configure(cfg *config.Config) {
+	PublicURL = cfg.Server.PublicURL
+	URL = PublicURL
+	CENTRAL_SERVER = cfg.Registration.CentralServer
+	RegistryToken = cfg.Registration.Token
+
+	heartbeat := cfg.Registration.Heartbeat
+	Heartbeat = HeartbeatConfig{
+		Interval:    heartbeat.Interval.D(),
+		Timeout:     heartbeat.Timeout.D(),
+		MaxRetries:  heartbeat.MaxRetries,
+		BackoffBase: heartbeat.BackoffBase.D(),
+		BackoffMax:  heartbeat.BackoffMax.D(),
+	}
+
+	StorageDirs = map[string]string{
+		"uploads": cfg.Storage.Uploads,
+		"results": cfg.Storage.Results,
+	}
+}
@@ -0,0 +1,212 @@
+package register
+
+import (
+	"UPC-GO/metrics"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 心跳配置，可以在配置文件的 registration.heartbeat 中修改
+
+// 心跳和注册的结果
+var (
+	heartbeatsTotal = metrics.NewCounterVec("upc_heartbeats_total",
+		"Heartbeats sent to the central server by result (success, failure).", "result")
+	registrationsTotal = metrics.NewCounterVec("upc_registrations_total",
+		"Registrations with the central server by result (success, failure).", "result")
+)
+
+// 注册中心的实例ID响应头，注册中心重启后这个值会变化
+const RegistryInstanceHeader = "X-Registry-Instance"
+
+// HeartbeatConfig 是心跳和重试的配置
+type HeartbeatConfig struct {
+	Interval    time.Duration `json:"interval"`
+	Timeout     time.Duration `json:"timeout"`
+	MaxRetries  int           `json:"maxRetries"`
+	BackoffBase time.Duration `json:"backoffBase"`
+	BackoffMax  time.Duration `json:"backoffMax"`
+}
+
+// MarshalJSON 把时间输出为 "60s" 这样的字符串
+func (c HeartbeatConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"interval":    c.Interval.String(),
+		"timeout":     c.Timeout.String(),
+		"maxRetries":  c.MaxRetries,
+		"backoffBase": c.BackoffBase.String(),
+		"backoffMax":  c.BackoffMax.String(),
+	})
+}
+
+// 当前的心跳配置
+var Heartbeat = HeartbeatConfig{
+	Interval:    60 * time.Second,
+	Timeout:     10 * time.Second,
+	MaxRetries:  5,
+	BackoffBase: time.Second,
+	BackoffMax:  60 * time.Second,
+}
+
+// Backoff 返回第 attempt 次重试前等待的时间，指数增长并加上随机抖动
+// 抖动让很多节点不会在注册中心重启后同时重试
+func (c HeartbeatConfig) Backoff(attempt int) time.Duration {
+	delay := c.BackoffBase
+	for i := 1; i < attempt && delay < c.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > c.BackoffMax {
+		delay = c.BackoffMax
+	}
+	// 在 [delay/2, delay) 之间随机
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// 注册状态
+const (
+	StateUnregistered = "unregistered" // 还没有注册，或者已经注销
+	StateRegistered   = "registered"   // 已经注册，心跳正常
+	StateRetrying     = "retrying"     // 注册中心返回错误，正在重试
+	StateDisconnected = "disconnected" // 连不上注册中心
+)
+
+// RegistrationStatus 是本节点当前的注册状态
+type RegistrationStatus struct {
+	State               string `json:"state"`
+	ID                  string `json:"id"`
+	URL                 string `json:"url"`
+	CentralServer       string `json:"centralServer"`
+	RegistryInstance    string `json:"registryInstance,omitempty"`
+	Registrations       int    `json:"registrations"` // 成功注册的次数，包括重新注册
+	LastRegistered      string `json:"lastRegistered,omitempty"`
+	LastHeartbeat       string `json:"lastHeartbeat,omitempty"` // 最后一次成功的心跳
+	NextHeartbeat       string `json:"nextHeartbeat,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	LastError           string `json:"lastError,omitempty"`
+
+	Heartbeat HeartbeatConfig `json:"heartbeat"`
+}
+
+type registrationState struct {
+	mu     sync.Mutex
+	status RegistrationStatus
+}
+
+var state = &registrationState{status: RegistrationStatus{State: StateUnregistered}}
+
+// Status 返回当前的注册状态
+func Status() RegistrationStatus {
+	status := state.Snapshot()
+	status.ID = id
+	status.URL = URL
+	status.CentralServer = CENTRAL_SERVER
+	status.Heartbeat = Heartbeat
+	return status
+}
+
+func (s *registrationState) Snapshot() RegistrationStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *registrationState) registered(instance string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Format(time.RFC3339)
+	registrationsTotal.Inc("success")
+	s.status.State = StateRegistered
+	s.status.RegistryInstance = instance
+	s.status.Registrations++
+	s.status.LastRegistered = now
+	s.status.LastHeartbeat = now
+	s.status.ConsecutiveFailures = 0
+	s.status.LastError = ""
+}
+
+func (s *registrationState) registerFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	registrationsTotal.Inc("failure")
+	s.status.State = StateUnregistered
+	s.status.ConsecutiveFailures++
+	s.status.LastError = err.Error()
+}
+
+func (s *registrationState) heartbeatSucceeded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	heartbeatsTotal.Inc("success")
+	s.status.State = StateRegistered
+	s.status.LastHeartbeat = time.Now().Format(time.RFC3339)
+	s.status.ConsecutiveFailures = 0
+	s.status.LastError = ""
+}
+
+// 心跳失败，disconnected 表示连不上注册中心
+func (s *registrationState) heartbeatFailed(err error, disconnected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	heartbeatsTotal.Inc("failure")
+	if disconnected {
+		s.status.State = StateDisconnected
+	} else {
+		s.status.State = StateRetrying
+	}
+	s.status.ConsecutiveFailures++
+	s.status.LastError = err.Error()
+}
+
+func (s *registrationState) unregistered() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.State = StateUnregistered
+	s.status.NextHeartbeat = ""
+}
+
+func (s *registrationState) scheduled(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.NextHeartbeat = next.Format(time.RFC3339)
+}
+
+// RunHeartbeat 定期发送心跳，直到 stop 被关闭，调用前应该已经调用过 RegisterService
+// 没有注册成功时会重新注册；失败后按指数退避重试，超过 MaxRetries 次后按正常间隔重试
+func RunHeartbeat(stop <-chan struct{}) {
+	ok := state.Snapshot().State == StateRegistered
+	for {
+		delay := Heartbeat.Interval
+		if failures := state.Snapshot().ConsecutiveFailures; !ok && failures <= Heartbeat.MaxRetries {
+			delay = Heartbeat.Backoff(failures)
+			slog.Info("Retrying registry connection", "delay", delay.Round(time.Millisecond).String(), "attempt", failures, "maxRetries", Heartbeat.MaxRetries)
+		}
+		state.scheduled(time.Now().Add(delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+
+		if state.Snapshot().State == StateUnregistered {
+			ok = register()
+			if ok {
+				slog.Info("Service registered successfully", "url", URL)
+			}
+		} else {
+			ok = SendHeartbeat()
+			if ok {
+				slog.Debug("Heartbeat sent successfully")
+			}
+		}
+	}
+}
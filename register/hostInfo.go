@@ -1,11 +1,17 @@
 package register
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/docker/docker/client"
 )
 
 // 得到本机IP
@@ -56,7 +62,13 @@ func GetUptime() (uint64, error) {
 	return uint64(uptimeSeconds), nil
 }
 
-// getHostInfo 获取主机信息
+// 本节点的存储目录，上报剩余空间
+var StorageDirs = map[string]string{
+	"uploads": "./uploads",
+	"results": "./results",
+}
+
+// getHostInfo 获取主机信息，每次心跳时重新获取
 func GetHostInfo() (map[string]interface{}, error) {
 	// 获取CPU架构和数量
 	architecture := runtime.GOARCH
@@ -69,16 +81,171 @@ func GetHostInfo() (map[string]interface{}, error) {
 		return nil, fmt.Errorf("failed to get hostname: %w", err)
 	}
 
-	// 获取操作系统平台和版本
+	// 获取操作系统平台和内核版本
 	platform := runtime.GOOS
-	release := runtime.Version()
+	release := GetKernelRelease()
 
-	return map[string]interface{}{
+	info := map[string]interface{}{
 		"hostname":     hostname,
 		"ip":           ip,
 		"architecture": architecture,
 		"cpus":         cpus,
 		"platform":     platform,
 		"release":      release,
+		"goVersion":    runtime.Version(),
+	}
+
+	// 下面的指标获取失败时不上报，不影响注册
+	if uptime, err := GetUptime(); err == nil {
+		info["uptime"] = uptime
+		info["uptimeHuman"] = FormatUptime(uptime)
+	}
+	if memory, err := GetMemoryInfo(); err == nil {
+		info["memory"] = memory
+	}
+	if load, err := GetLoadAverage(); err == nil {
+		info["load"] = load
+	}
+	disks := make(map[string]interface{})
+	for name, dir := range StorageDirs {
+		if disk, err := GetDiskUsage(dir); err == nil {
+			disks[name] = disk
+		}
+	}
+	info["disk"] = disks
+	info["docker"] = GetDockerInfo()
+
+	return info, nil
+}
+
+// GetKernelRelease 获取内核版本（Linux），获取失败时返回Go的版本
+func GetKernelRelease() string {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return runtime.Version()
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// MemoryInfo 是内存使用情况，单位是字节
+type MemoryInfo struct {
+	Total       uint64  `json:"total"`
+	Available   uint64  `json:"available"`
+	TotalGB     float64 `json:"totalGB"`
+	AvailableGB float64 `json:"availableGB"`
+}
+
+// GetMemoryInfo 从 /proc/meminfo 获取内存使用情况（Linux）
+func GetMemoryInfo() (*MemoryInfo, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 每行的格式是 "MemTotal:       16314512 kB"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) == 3 && fields[2] == "kB" {
+			value *= 1024
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	total, ok := values["MemTotal"]
+	if !ok {
+		return nil, fmt.Errorf("MemTotal not found in /proc/meminfo")
+	}
+	// 老的内核没有 MemAvailable，用空闲内存加缓存估算
+	available, ok := values["MemAvailable"]
+	if !ok {
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	return &MemoryInfo{
+		Total:       total,
+		Available:   available,
+		TotalGB:     BytesToGB(total),
+		AvailableGB: BytesToGB(available),
 	}, nil
 }
+
+// LoadAverage 是1分钟、5分钟和15分钟的平均负载
+type LoadAverage struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// GetLoadAverage 从 /proc/loadavg 获取平均负载（Linux）
+func GetLoadAverage() (*LoadAverage, error) {
+	file, err := os.Open("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var load LoadAverage
+	if _, err := fmt.Fscanf(file, "%f %f %f", &load.Load1, &load.Load5, &load.Load15); err != nil {
+		return nil, err
+	}
+	return &load, nil
+}
+
+// DiskUsage 是一个目录所在文件系统的空间，单位是字节
+type DiskUsage struct {
+	Path        string  `json:"path"`
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	Available   uint64  `json:"available"` // 非root用户可用的空间
+	AvailableGB float64 `json:"availableGB"`
+}
+
+// GetDiskUsage 获取目录所在文件系统的空间，实现见 hostInfo_unix.go 和 hostInfo_windows.go
+
+// 获取Docker信息的超时时间，Docker没有响应时不能拖慢心跳
+const dockerInfoTimeout = 3 * time.Second
+
+// DockerInfo 是Docker守护进程的信息
+type DockerInfo struct {
+	Available         bool   `json:"available"`
+	ServerVersion     string `json:"serverVersion,omitempty"`
+	Containers        int    `json:"containers"`
+	ContainersRunning int    `json:"containersRunning"`
+	Images            int    `json:"images"`
+	Error             string `json:"error,omitempty"`
+}
+
+// GetDockerInfo 获取Docker守护进程的信息，连不上Docker时 Available 为 false
+func GetDockerInfo() *DockerInfo {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return &DockerInfo{Error: err.Error()}
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerInfoTimeout)
+	defer cancel()
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return &DockerInfo{Error: err.Error()}
+	}
+	return &DockerInfo{
+		Available:         true,
+		ServerVersion:     info.ServerVersion,
+		Containers:        info.Containers,
+		ContainersRunning: info.ContainersRunning,
+		Images:            info.Images,
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package register
+
+import "syscall"
+
+// GetDiskUsage 获取目录所在文件系统的空间
+func GetDiskUsage(dir string) (*DiskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return nil, err
+	}
+	blockSize := uint64(stat.Bsize)
+	available := stat.Bavail * blockSize
+	return &DiskUsage{
+		Path:        dir,
+		Total:       stat.Blocks * blockSize,
+		Free:        stat.Bfree * blockSize,
+		Available:   available,
+		AvailableGB: BytesToGB(available),
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package register
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// GetDiskUsage 获取目录所在磁盘的空间，Windows 没有 statfs，使用 GetDiskFreeSpaceEx
+func GetDiskUsage(dir string) (*DiskUsage, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return nil, err
+	}
+	var available, total, free uint64
+	ok, _, err := getDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&available)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&free)),
+	)
+	if ok == 0 {
+		return nil, err
+	}
+	return &DiskUsage{
+		Path:        dir,
+		Total:       total,
+		Free:        free,
+		Available:   available,
+		AvailableGB: BytesToGB(available),
+	}, nil
+}
@@ -2,13 +2,14 @@ package register
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
-	"strings"
-	"time"
+	"net/url"
 )
 
 // 服务信息结构
@@ -19,47 +20,61 @@ type ServiceInfo struct {
 	HostInfo  map[string]interface{} `json:"hostInfo"`
 }
 
-// 获取环境变量中的注册服务URL
-func GetCentralServer() string {
-	centralServer := os.Getenv("CENTRAL_SERVER")
-	if centralServer == "" {
-		centralServer = "http://localhost:8000" // 默认值
+// withPort 函数把URL中的端口号替换为 port, 例如 http://localhost:4000 -> http://localhost:4555
+// 没有端口号的URL原样返回, 例如反向代理后面的 https://upc.example.com
+func withPort(rawURL, port string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || u.Port() == "" {
+		return rawURL
 	}
-	return centralServer
+	u.Host = net.JoinHostPort(u.Hostname(), port)
+	return u.String()
 }
 
-// 获取环境变量中的本后端服务URL
-func GetGoAPIURL() string {
-	goAPIURL := os.Getenv("API_URL")
-	if goAPIURL == "" {
-		goAPIURL = "http://localhost:4000" // 默认值
+// 全局变量，可以在配置文件的 server.publicUrl 和 registration 中修改
+var (
+	PublicURL      = "http://localhost:4000" // 本后端服务的URL，注册时端口会被替换为监听的端口，没有端口时原样使用
+	URL            = PublicURL
+	CENTRAL_SERVER = "http://localhost:8000" // 注册中心的URL
+	id             = "GO Server: "           // 替换为你的服务ID
+)
+
+// 注册中心的令牌，内置注册中心设置了 registration.token 时需要
+var RegistryToken string
+
+// 请求 https 注册中心时使用的TLS配置，包括信任的CA和 mTLS 的客户端证书
+var TLSClientConfig *tls.Config
+
+// 创建请求注册中心的HTTP客户端
+func httpClient() *http.Client {
+	client := &http.Client{Timeout: Heartbeat.Timeout}
+	if TLSClientConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = TLSClientConfig
+		client.Transport = transport
 	}
-	return goAPIURL
+	return client
 }
 
-// removePort 函数移除URL中的端口号, 例如 http://localhost:4000 -> http://localhost
-func removePort(rawURL string) string {
-	if strings.Contains(rawURL, ":") {
-		return rawURL[:strings.LastIndex(rawURL, ":")]
+func setRegistryHeaders(req *http.Request) {
+	if RegistryToken != "" {
+		req.Header.Set("X-Registry-Token", RegistryToken)
 	}
-	return rawURL
 }
 
-// 全局变量
-var (
-	URL            = GetGoAPIURL()
-	CENTRAL_SERVER = GetCentralServer()
-	id             = "GO Server: " // 替换为你的服务ID
-	hostInfo, _    = GetHostInfo()
-)
-
-func RegisterService(port string) bool {
-	// 去掉端口号，然后添加新的端口号
-	URL = removePort(URL)
+// 注册结果
+type registryResponse struct {
+	StatusCode int
+	Instance   string // 注册中心的实例ID，注册中心重启后会变化
+}
 
-	// 通过指针修改全局变量
-	URL = URL + ":" + port
-	id = id + URL
+// 把服务信息发送到注册中心，注册和心跳使用同一个接口
+func postServiceInfo(heartbeat bool) (*registryResponse, error) {
+	// 每次注册和心跳都重新获取主机信息，让注册中心看到最新的负载
+	hostInfo, err := GetHostInfo()
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建服务信息
 	serviceInfo := ServiceInfo{
@@ -72,79 +87,90 @@ func RegisterService(port string) bool {
 	// 将服务信息转换为json格式
 	jsonData, err := json.Marshal(serviceInfo) // 将服务信息转换为json格式
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	// 创建HTTP客户端
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := httpClient()
 
 	// 创建HTTP请求
 	req, err := http.NewRequest("POST", fmt.Sprintf("%s/backend/register-service", CENTRAL_SERVER), bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("Failed to create request: %s\n", err.Error())
-		return false
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setRegistryHeaders(req)
+	if heartbeat {
+		// 内置注册中心对不认识的节点的心跳返回404
+		req.Header.Set("X-Heartbeat", "true")
+	}
 
 	// 发送请求
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("Failed to register service: %s\n", err.Error())
-		return false
+		return nil, err
 	}
 	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
 
-	// 检查响应状态码
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return true
-	} else {
-		fmt.Printf("Failed to register service: %s\n", resp.Status)
-		return false
-	}
+	return &registryResponse{StatusCode: resp.StatusCode, Instance: resp.Header.Get(RegistryInstanceHeader)}, nil
 }
 
-// 发送心跳功能
-func SendHeartbeat() bool {
-	// 创建服务信息
-	serviceInfo := ServiceInfo{
-		ID:        id,
-		URL:       URL,
-		PublicURL: URL,
-		HostInfo:  hostInfo,
-	}
+// RegisterService 使用端口号生成本服务的URL和ID，然后注册到注册中心
+// 可以重复调用，例如注册中心重启后重新注册
+func RegisterService(port string) bool {
+	// 替换为监听的端口号
+	URL = withPort(PublicURL, port)
+	id = "GO Server: " + URL
+	return register()
+}
 
-	// 将服务信息转换为json格式
-	jsonData, err := json.Marshal(serviceInfo) // 将服务信息转换为json格式
-	if err != nil {
-		log.Fatal(err)
+// 注册到注册中心，并记录注册状态
+func register() bool {
+	resp, err := postServiceInfo(false)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		err = fmt.Errorf("unexpected status %d", resp.StatusCode)
 	}
-
-	// 创建HTTP客户端
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	// 创建HTTP请求
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/backend/register-service", CENTRAL_SERVER), bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("Failed to create request: %s\n", err.Error())
+		slog.Warn("Failed to register service", "central", CENTRAL_SERVER, "error", err)
+		state.registerFailed(err)
 		return false
 	}
-	req.Header.Set("Content-Type", "application/json")
+	state.registered(resp.Instance)
+	return true
+}
 
-	// 发送请求
-	resp, err := client.Do(req)
+// SendHeartbeat 发送一次心跳，注册中心返回404或者重启后会重新注册
+func SendHeartbeat() bool {
+	resp, err := postServiceInfo(true)
 	if err != nil {
-		fmt.Printf("Failed to register service: %s\n", err.Error())
+		slog.Warn("Failed to send heartbeat", "central", CENTRAL_SERVER, "error", err)
+		state.heartbeatFailed(err, true)
 		return false
 	}
-	defer resp.Body.Close()
 
-	// 检查响应状态码
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return true
-	} else {
-		fmt.Printf("Failed to register service: %s\n", resp.Status)
+	// 注册中心不认识我们了，或者注册中心已经重启，重新注册
+	instance := state.Snapshot().RegistryInstance
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone ||
+		(resp.Instance != "" && instance != "" && resp.Instance != instance) {
+		slog.Warn("Central server lost our registration, registering again", "status", resp.StatusCode)
+		state.heartbeatFailed(fmt.Errorf("central server returned %d", resp.StatusCode), false)
+		return register()
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("unexpected status %d", resp.StatusCode)
+		slog.Warn("Failed to send heartbeat", "central", CENTRAL_SERVER, "error", err)
+		state.heartbeatFailed(err, false)
 		return false
 	}
+
+	// 之前连不上注册中心，它可能已经重启并丢失了注册信息
+	if state.Snapshot().State == StateDisconnected {
+		return register()
+	}
+	state.heartbeatSucceeded()
+	return true
 }
 
 // 注销请求结构
@@ -159,31 +185,33 @@ func UnregisterService() {
 	// 将注销请求转换为json格式
 	jsonData, err := json.Marshal(unregisterReq)
 	if err != nil {
-		fmt.Printf("Failed to marshal unregister request: %s\n", err.Error())
+		slog.Error("Failed to marshal unregister request", "error", err)
 		return
 	}
 
 	// 创建HTTP客户端
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := httpClient()
 	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/backend/unregister-service", CENTRAL_SERVER), bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("Failed to create request: %s\n", err.Error())
+		slog.Error("Failed to create unregister request", "error", err)
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setRegistryHeaders(req)
 
 	// 发送请求
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("Failed to unregister service: %s\n", err.Error())
+		slog.Warn("Failed to unregister service", "error", err)
 		return
 	}
 	defer resp.Body.Close()
 
 	// 检查响应状态码
 	if resp.StatusCode == http.StatusOK {
-		fmt.Println("Service unregistered")
+		slog.Info("Service unregistered")
+		state.unregistered()
 	} else {
-		fmt.Printf("Failed to unregister service: %s\n", resp.Status)
+		slog.Warn("Failed to unregister service", "status", resp.Status)
 	}
 }
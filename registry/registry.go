@@ -0,0 +1,352 @@
+package registry
+
+import (
+	"UPC-GO/api"
+	"UPC-GO/logging"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置的注册中心，使用 -registry 启动，代替单独的 Node 注册中心:
+//   post   /backend/register-service   注册或者心跳，心跳请求带 X-Heartbeat 头
+//   delete /backend/unregister-service 注销
+//   get    /api/nodes                  获取所有节点的列表
+//   get    /api/nodes/:key             获取一个节点的信息
+//   any    /api/nodes/:key/*           转发到节点，例如 /api/nodes/:key/api/files 转发到节点的 /api/files
+// 超过 TTL 没有心跳的节点会被移除，TTL 在配置文件的 registry.nodeTTL 中修改，默认 3m
+// 节点注册和心跳需要在 X-Registry-Token 头中带上 registration.token，没有配置令牌时拒绝所有注册
+// 只转发节点上的 /api 和 /ws，转发时换成节点信任的凭证:
+//   设置了 JWTSecret 时，用它签名一个短期的 JWT，带上调用者的用户名和角色，节点需要配置同一个 auth.jwtSecret
+//   否则原样转发调用者的 token，节点需要和注册中心使用相同的认证配置
+// 注册中心的令牌和 ?token= 查询参数不会发给节点
+
+// 注册中心的实例ID响应头，和 register 包中的一致
+const instanceHeader = "X-Registry-Instance"
+
+// 转发到节点的 JWT 的有效期，只需要覆盖一个请求或者 WebSocket 握手
+const forwardTokenTTL = time.Minute
+
+// Node 是一个注册的节点
+type Node struct {
+	Key        string                 `json:"key"` // 节点ID的哈希，用在URL中
+	ID         string                 `json:"_id"`
+	URL        string                 `json:"url"`
+	PublicURL  string                 `json:"publicUrl"`
+	HostInfo   map[string]interface{} `json:"hostInfo"`
+	Registered string                 `json:"registered"`
+	LastSeen   string                 `json:"lastSeen"`
+	ExpiresAt  string                 `json:"expiresAt"`
+
+	lastSeen time.Time
+	proxy    *httputil.ReverseProxy
+}
+
+// Registry 保存所有注册的节点
+type Registry struct {
+	mu       sync.Mutex
+	nodes    map[string]*Node // key -> node
+	ttl      time.Duration
+	token    string
+	instance string
+
+	// 转发到节点时使用，节点启用了 mTLS 时需要客户端证书
+	transport http.RoundTripper
+
+	// JWTSecret 不为空时，转发的请求带上用它签名的 JWT，代替调用者的 token，在 New 之后设置
+	JWTSecret []byte
+}
+
+// New 创建一个注册中心，并开始定期移除过期的节点，clientTLS 用于转发到 https 的节点
+func New(ttl time.Duration, token string, clientTLS *tls.Config) *Registry {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	r := &Registry{
+		nodes:    make(map[string]*Node),
+		ttl:      ttl,
+		token:    token,
+		instance: hex.EncodeToString(buf),
+	}
+	if clientTLS != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = clientTLS
+		r.transport = transport
+	}
+	go r.expireLoop()
+	return r
+}
+
+// Register 注册注册中心的路由
+func (reg *Registry) Register(rt *api.Router) {
+	rt.HandleFunc("POST /backend/register-service", reg.RegisterHandler)       // 注册或者心跳
+	rt.HandleFunc("DELETE /backend/unregister-service", reg.UnregisterHandler) // 注销
+	rt.API("GET /nodes", reg.NodesHandler)                                     // get /api/v1/nodes 获取所有节点的列表
+	rt.API("GET /nodes/{key}", reg.NodeHandler)                                // get /api/v1/nodes/:key 获取节点信息
+	rt.API("/nodes/{key}/{rest...}", reg.NodeProxy)                            // /api/v1/nodes/:key/* 转发到节点，所有方法
+	slog.Info("Registry enabled", "instance", reg.instance, "nodeTTL", reg.ttl.String())
+}
+
+// 节点ID中有空格和斜杠，用哈希作为URL中的key
+func nodeKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:6])
+}
+
+// 定期移除过期的节点
+func (reg *Registry) expireLoop() {
+	ticker := time.NewTicker(reg.ttl / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		reg.expire(time.Now())
+	}
+}
+
+func (reg *Registry) expire(now time.Time) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for key, node := range reg.nodes {
+		if now.Sub(node.lastSeen) > reg.ttl {
+			delete(reg.nodes, key)
+			slog.Info("Node expired", "node", node.ID)
+		}
+	}
+}
+
+// 返回节点的副本，避免在锁外读写
+func (reg *Registry) info(node *Node) Node {
+	info := *node
+	info.LastSeen = node.lastSeen.Format(time.RFC3339)
+	info.ExpiresAt = node.lastSeen.Add(reg.ttl).Format(time.RFC3339)
+	info.proxy = nil
+	return info
+}
+
+// Nodes 返回所有节点，按注册时间排序
+func (reg *Registry) Nodes() []Node {
+	reg.mu.Lock()
+	nodes := make([]Node, 0, len(reg.nodes))
+	for _, node := range reg.nodes {
+		nodes = append(nodes, reg.info(node))
+	}
+	reg.mu.Unlock()
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Registered != nodes[j].Registered {
+			return nodes[i].Registered < nodes[j].Registered
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// 检查节点的注册令牌，没有令牌时不允许注册
+func (reg *Registry) authorized(r *http.Request) bool {
+	if reg.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Registry-Token")), []byte(reg.token)) == 1
+}
+
+// post /backend/register-service 注册一个节点或者更新它的心跳
+// 心跳请求带 X-Heartbeat 头，节点已经过期时返回404，让节点重新注册
+func (reg *Registry) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	api.Cors(w)
+	w.Header().Set(instanceHeader, reg.instance)
+	if !reg.authorized(r) {
+		api.WriteError(w, http.StatusUnauthorized, "Invalid registry token", nil)
+		return
+	}
+
+	var node Node
+	if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "Invalid service info", err)
+		return
+	}
+	if node.ID == "" || node.URL == "" {
+		api.WriteError(w, http.StatusBadRequest, "Service info needs _id and url", nil)
+		return
+	}
+	target, err := url.Parse(node.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		api.WriteError(w, http.StatusBadRequest, "Invalid service url: "+node.URL, err)
+		return
+	}
+	if node.PublicURL == "" {
+		node.PublicURL = node.URL
+	}
+	node.Key = nodeKey(node.ID)
+	heartbeat := r.Header.Get("X-Heartbeat") != ""
+
+	reg.mu.Lock()
+	existing := reg.nodes[node.Key]
+	if existing == nil && heartbeat {
+		reg.mu.Unlock()
+		api.WriteError(w, http.StatusNotFound, "Unknown node, register again: "+node.ID, nil)
+		return
+	}
+	// 改变节点地址需要重新注册，心跳不能改变地址，注册已经检查过令牌
+	if existing != nil && heartbeat && existing.URL != node.URL {
+		reg.mu.Unlock()
+		api.WriteError(w, http.StatusConflict, "Heartbeat url does not match the registered url, register again: "+node.ID, nil)
+		return
+	}
+	now := time.Now()
+	if existing != nil && existing.URL == node.URL {
+		node.Registered = existing.Registered
+		node.proxy = existing.proxy
+	} else {
+		node.Registered = now.Format(time.RFC3339)
+		node.proxy = reg.newProxy(target)
+		if existing != nil {
+			slog.WarnContext(r.Context(), "Node url changed", "node", node.ID, "from", existing.URL, "to", node.URL)
+		}
+		slog.InfoContext(r.Context(), "Node registered", "node", node.ID)
+	}
+	node.lastSeen = now
+	reg.nodes[node.Key] = &node
+	info := reg.info(&node)
+	reg.mu.Unlock()
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// delete /backend/unregister-service 注销一个节点
+func (reg *Registry) UnregisterHandler(w http.ResponseWriter, r *http.Request) {
+	api.Cors(w)
+	w.Header().Set(instanceHeader, reg.instance)
+	if !reg.authorized(r) {
+		api.WriteError(w, http.StatusUnauthorized, "Invalid registry token", nil)
+		return
+	}
+
+	var req struct {
+		ID string `json:"_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "Invalid unregister request", err)
+		return
+	}
+
+	key := nodeKey(req.ID)
+	reg.mu.Lock()
+	_, ok := reg.nodes[key]
+	delete(reg.nodes, key)
+	reg.mu.Unlock()
+	if !ok {
+		api.WriteError(w, http.StatusNotFound, "Node not found: "+req.ID, nil)
+		return
+	}
+	slog.InfoContext(r.Context(), "Node unregistered", "node", req.ID)
+	json.NewEncoder(w).Encode("Node unregistered: " + req.ID)
+}
+
+// get /api/nodes 获取所有节点的列表
+func (reg *Registry) NodesHandler(w http.ResponseWriter, r *http.Request) {
+	api.Cors(w)
+	json.NewEncoder(w).Encode(reg.Nodes())
+}
+
+// 找到一个节点，调用时不需要持有 reg.mu
+func (reg *Registry) node(key string) (*Node, Node) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	node := reg.nodes[key]
+	if node == nil {
+		return nil, Node{}
+	}
+	return node, reg.info(node)
+}
+
+// get /api/v1/nodes/:key 获取节点信息
+func (reg *Registry) NodeHandler(w http.ResponseWriter, r *http.Request) {
+	api.Cors(w)
+	key := r.PathValue("key")
+	node, info := reg.node(key)
+	if node == nil {
+		api.WriteError(w, http.StatusNotFound, "Node not found: "+key, nil)
+		return
+	}
+	json.NewEncoder(w).Encode(info)
+}
+
+// /api/v1/nodes/:key/* 转发到节点，例如 /api/v1/nodes/:key/api/v1/files 转发为节点上的 /api/v1/files
+func (reg *Registry) NodeProxy(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	node, _ := reg.node(key)
+	if node == nil {
+		api.Cors(w)
+		api.WriteError(w, http.StatusNotFound, "Node not found: "+key, nil)
+		return
+	}
+	// 只转发节点的接口和终端，节点上的其它路径不通过注册中心公开
+	rest := r.PathValue("rest")
+	if !proxiedPath(rest) {
+		api.Cors(w)
+		api.WriteError(w, http.StatusNotFound, "Only /api and /ws paths are forwarded to nodes: /"+rest, nil)
+		return
+	}
+	// 把请求路径改写为节点上的路径，再转发
+	out := r.Clone(r.Context())
+	out.URL.Path = "/" + rest
+	out.URL.RawPath = ""
+	node.proxy.ServeHTTP(w, out)
+}
+
+// 转发给节点的 token，设置了 JWTSecret 时签名一个带调用者用户名和角色的短期 JWT，否则是调用者自己的 token
+func (reg *Registry) forwardToken(r *http.Request) string {
+	if len(reg.JWTSecret) > 0 {
+		if p := api.PrincipalFrom(r.Context()); p != nil {
+			return api.SignJWT(reg.JWTSecret, p.Name, p.Role, forwardTokenTTL)
+		}
+	}
+	return api.RequestToken(r)
+}
+
+// 可以转发到节点的路径，rest 不带开头的斜杠
+func proxiedPath(rest string) bool {
+	return rest == "api" || strings.HasPrefix(rest, "api/") || rest == "ws"
+}
+
+// 创建转发到节点的反向代理，WebSocket 连接也可以转发
+func (reg *Registry) newProxy(target *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Transport: reg.transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			// 去掉调用者的凭证和注册中心的令牌，换成节点信任的凭证
+			for _, header := range []string{"Authorization", "X-API-Key", "X-Registry-Token"} {
+				pr.Out.Header.Del(header)
+			}
+			if query := pr.Out.URL.Query(); query.Has("token") {
+				query.Del("token")
+				pr.Out.URL.RawQuery = query.Encode()
+			}
+			if token := reg.forwardToken(pr.In); token != "" {
+				pr.Out.Header.Set("Authorization", "Bearer "+token)
+			}
+		},
+		// 构建日志和拉取进度是流式的，立即转发
+		FlushInterval: -1,
+		// 请求ID已经转发给节点，节点返回的是同一个ID，不需要重复
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del(logging.RequestIDHeader)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.WarnContext(r.Context(), "Node unreachable", "node", target.String(), "error", err)
+			api.Cors(w)
+			api.WriteError(w, http.StatusBadGateway, "Node unreachable: "+target.String(), err)
+		},
+	}
+}
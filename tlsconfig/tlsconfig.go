@@ -0,0 +1,199 @@
+package tlsconfig
+
+import (
+	"UPC-GO/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	fpath "path/filepath"
+	"time"
+)
+
+// 自签名证书的有效期，过期后下次启动时重新生成
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// 证书和私钥的路径，没有配置时使用自签名证书
+func certPaths(c config.TLS) (certFile, keyFile string, selfSigned bool) {
+	if c.CertFile != "" {
+		return c.CertFile, c.KeyFile, false
+	}
+	return fpath.Join(c.SelfSignedDir, "cert.pem"), fpath.Join(c.SelfSignedDir, "key.pem"), true
+}
+
+// Server 返回HTTPS服务器使用的TLS配置
+// 没有配置证书时，第一次启动会生成自签名证书，之后一直使用同一个证书
+func Server(cfg *config.Config) (*tls.Config, error) {
+	c := cfg.Server.TLS
+	certFile, keyFile, selfSigned := certPaths(c)
+	if selfSigned {
+		if err := ensureSelfSigned(certFile, keyFile, certHosts(cfg)); err != nil {
+			return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// 客户端证书 (mTLS)
+	switch c.ClientAuth {
+	case config.ClientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if c.ClientCAFile != "" {
+		pool, err := loadCertPool(c.ClientCAFile, false)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// Client 返回请求注册中心和其它节点时使用的TLS配置
+// 启用了TLS时，本节点的证书同时作为客户端证书，对方要求 mTLS 时使用
+func Client(cfg *config.Config) (*tls.Config, error) {
+	c := cfg.Server.TLS
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile, true)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.Enabled {
+		certFile, keyFile, _ := certPaths(c)
+		if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return tlsConfig, nil
+}
+
+// 读取PEM格式的CA证书，withSystem 为 true 时同时信任系统的CA
+func loadCertPool(file string, withSystem bool) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if withSystem {
+		if system, err := x509.SystemCertPool(); err == nil {
+			pool = system
+		}
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// 自签名证书中包含的主机名和IP
+func certHosts(cfg *config.Config) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	if u, err := url.Parse(cfg.Server.PublicURL); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+	if host, _, err := net.SplitHostPort(cfg.Server.Listen); err == nil && host != "" {
+		hosts = append(hosts, host)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+	return hosts
+}
+
+// 证书存在且没有过期时直接使用，否则生成一个新的自签名证书
+func ensureSelfSigned(certFile, keyFile string, hosts []string) error {
+	if data, err := os.ReadFile(certFile); err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil && time.Now().Before(cert.NotAfter) {
+				if _, err := os.Stat(keyFile); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostname, Organization: []string{"UPC-GO self-signed"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		// 同时作为服务器证书和客户端证书
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(fpath.Dir(certFile), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	slog.Info("Generated self-signed certificate", "cert", certFile)
+	return nil
+}